@@ -5,8 +5,11 @@ go 1.13
 require (
 	github.com/cpuguy83/go-md2man v1.0.10 // indirect
 	github.com/elazarl/goproxy v0.0.0-20220328115640-894aeddb713e
+	github.com/fsnotify/fsnotify v1.4.7
 	github.com/golang/protobuf v1.5.2
 	github.com/grpc-ecosystem/go-grpc-middleware v1.3.0
+	github.com/grpc-ecosystem/go-grpc-prometheus v1.2.0
+	github.com/hashicorp/yamux v0.1.1
 	github.com/inhies/go-bytesize v0.0.0-20210819104631-275770b98743
 	github.com/konsorten/go-windows-terminal-sequences v1.0.3 // indirect
 	github.com/labstack/echo v3.3.10+incompatible
@@ -14,15 +17,20 @@ require (
 	github.com/mattn/go-colorable v0.1.12 // indirect
 	github.com/mattn/go-runewidth v0.0.13 // indirect
 	github.com/mitchellh/go-homedir v1.1.0 // indirect
+	github.com/mitchellh/mapstructure v1.1.2
 	github.com/mwitkow/go-proto-validators v0.3.2
 	github.com/olekukonko/tablewriter v0.0.5
+	github.com/prometheus/client_golang v1.11.1
 	github.com/satori/go.uuid v1.2.0
 	github.com/sirupsen/logrus v1.8.1
+	github.com/spf13/cast v1.3.0
 	github.com/spf13/cobra v1.4.0
-	github.com/spf13/viper v1.3.2 // indirect
+	github.com/spf13/pflag v1.0.5
+	github.com/spf13/viper v1.3.2
 	golang.org/x/crypto v0.0.0-20220321153916-2c7772ba3064 // indirect
 	golang.org/x/net v0.0.0-20220325170049-de3da57026de // indirect
-	golang.org/x/sys v0.0.0-20220330033206-e17cdc41300f // indirect
+	golang.org/x/sys v0.0.0-20220330033206-e17cdc41300f
+	golang.org/x/time v0.0.0-20210723032227-1f47c861a9ac
 	google.golang.org/genproto v0.0.0-20220329172620-7be39ac1afc7 // indirect
 	google.golang.org/grpc v1.45.0
 )
@@ -26,12 +26,39 @@ var _ = math.Inf
 const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
 
 type CreateClientRequest struct {
-	DisplayName          string   `protobuf:"bytes,1,opt,name=display_name,json=displayName,proto3" json:"display_name,omitempty"`
-	InternalPort         int32    `protobuf:"varint,2,opt,name=internal_port,json=internalPort,proto3" json:"internal_port,omitempty"`
-	PublicPort           int32    `protobuf:"varint,3,opt,name=public_port,json=publicPort,proto3" json:"public_port,omitempty"`
-	SharePublicAddr      bool     `protobuf:"varint,4,opt,name=share_public_addr,json=sharePublicAddr,proto3" json:"share_public_addr,omitempty"`
-	Protocol             string   `protobuf:"bytes,5,opt,name=protocol,proto3" json:"protocol,omitempty"`
-	BackendPort          int32    `protobuf:"varint,6,opt,name=backend_port,json=backendPort,proto3" json:"backend_port,omitempty"`
+	DisplayName     string `protobuf:"bytes,1,opt,name=display_name,json=displayName,proto3" json:"display_name,omitempty"`
+	InternalPort    int32  `protobuf:"varint,2,opt,name=internal_port,json=internalPort,proto3" json:"internal_port,omitempty"`
+	PublicPort      int32  `protobuf:"varint,3,opt,name=public_port,json=publicPort,proto3" json:"public_port,omitempty"`
+	SharePublicAddr bool   `protobuf:"varint,4,opt,name=share_public_addr,json=sharePublicAddr,proto3" json:"share_public_addr,omitempty"`
+	Protocol        string `protobuf:"bytes,5,opt,name=protocol,proto3" json:"protocol,omitempty"`
+	BackendPort     int32  `protobuf:"varint,6,opt,name=backend_port,json=backendPort,proto3" json:"backend_port,omitempty"`
+	// data connections are streams in the tunnel instead of connections to internal port.
+	Multiplex bool `protobuf:"varint,7,opt,name=multiplex,proto3" json:"multiplex,omitempty"`
+	// rate limits in bytes per second, 0 means unlimited.
+	// in is from backend service users to backend, out is the reverse.
+	RateLimitIn  uint64 `protobuf:"varint,8,opt,name=rate_limit_in,json=rateLimitIn,proto3" json:"rate_limit_in,omitempty"`
+	RateLimitOut uint64 `protobuf:"varint,9,opt,name=rate_limit_out,json=rateLimitOut,proto3" json:"rate_limit_out,omitempty"`
+	// rate limits of each backend service user.
+	UserRateLimitIn  uint64 `protobuf:"varint,10,opt,name=user_rate_limit_in,json=userRateLimitIn,proto3" json:"user_rate_limit_in,omitempty"`
+	UserRateLimitOut uint64 `protobuf:"varint,11,opt,name=user_rate_limit_out,json=userRateLimitOut,proto3" json:"user_rate_limit_out,omitempty"`
+	// max concurrent backend service users, 0 means unlimited.
+	MaxUsers   int32       `protobuf:"varint,12,opt,name=max_users,json=maxUsers,proto3" json:"max_users,omitempty"`
+	AccessList *AccessList `protobuf:"bytes,13,opt,name=access_list,json=accessList,proto3" json:"access_list,omitempty"`
+	// policy balancing users of the shared public port: round_robin(default),
+	// least_conn, weighted or source_hash, clients sharing a port must agree.
+	LbPolicy string `protobuf:"bytes,14,opt,name=lb_policy,json=lbPolicy,proto3" json:"lb_policy,omitempty"`
+	// weight of the client for weighted policy, 1 if 0.
+	Weight int32 `protobuf:"varint,15,opt,name=weight,proto3" json:"weight,omitempty"`
+	// in seconds, server defaults are used if 0.
+	// idle_timeout closes users without bytes in either direction,
+	// max_session_duration closes users connected for the duration,
+	// keepalive_interval is tcp keepalive period of public and internal connections.
+	IdleTimeout        int32 `protobuf:"varint,16,opt,name=idle_timeout,json=idleTimeout,proto3" json:"idle_timeout,omitempty"`
+	MaxSessionDuration int32 `protobuf:"varint,17,opt,name=max_session_duration,json=maxSessionDuration,proto3" json:"max_session_duration,omitempty"`
+	KeepaliveInterval  int32 `protobuf:"varint,18,opt,name=keepalive_interval,json=keepaliveInterval,proto3" json:"keepalive_interval,omitempty"`
+	// session_id of a client created before, the client is resumed with its name
+	// and public address if it is kept by server, a new client is created otherwise.
+	SessionId            string   `protobuf:"bytes,19,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -104,6 +131,193 @@ func (m *CreateClientRequest) GetBackendPort() int32 {
 	return 0
 }
 
+func (m *CreateClientRequest) GetMultiplex() bool {
+	if m != nil {
+		return m.Multiplex
+	}
+	return false
+}
+
+func (m *CreateClientRequest) GetRateLimitIn() uint64 {
+	if m != nil {
+		return m.RateLimitIn
+	}
+	return 0
+}
+
+func (m *CreateClientRequest) GetRateLimitOut() uint64 {
+	if m != nil {
+		return m.RateLimitOut
+	}
+	return 0
+}
+
+func (m *CreateClientRequest) GetUserRateLimitIn() uint64 {
+	if m != nil {
+		return m.UserRateLimitIn
+	}
+	return 0
+}
+
+func (m *CreateClientRequest) GetUserRateLimitOut() uint64 {
+	if m != nil {
+		return m.UserRateLimitOut
+	}
+	return 0
+}
+
+func (m *CreateClientRequest) GetMaxUsers() int32 {
+	if m != nil {
+		return m.MaxUsers
+	}
+	return 0
+}
+
+func (m *CreateClientRequest) GetAccessList() *AccessList {
+	if m != nil {
+		return m.AccessList
+	}
+	return nil
+}
+
+func (m *CreateClientRequest) GetLbPolicy() string {
+	if m != nil {
+		return m.LbPolicy
+	}
+	return ""
+}
+
+func (m *CreateClientRequest) GetWeight() int32 {
+	if m != nil {
+		return m.Weight
+	}
+	return 0
+}
+
+func (m *CreateClientRequest) GetIdleTimeout() int32 {
+	if m != nil {
+		return m.IdleTimeout
+	}
+	return 0
+}
+
+func (m *CreateClientRequest) GetMaxSessionDuration() int32 {
+	if m != nil {
+		return m.MaxSessionDuration
+	}
+	return 0
+}
+
+func (m *CreateClientRequest) GetKeepaliveInterval() int32 {
+	if m != nil {
+		return m.KeepaliveInterval
+	}
+	return 0
+}
+
+func (m *CreateClientRequest) GetSessionId() string {
+	if m != nil {
+		return m.SessionId
+	}
+	return ""
+}
+
+// AccessList allows or denies backend service users by source ip, items are cidrs or ips.
+// Deny takes precedence, all ips are allowed if allow is empty.
+type AccessList struct {
+	Allow                []string `protobuf:"bytes,1,rep,name=allow,proto3" json:"allow,omitempty"`
+	Deny                 []string `protobuf:"bytes,2,rep,name=deny,proto3" json:"deny,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *AccessList) Reset()         { *m = AccessList{} }
+func (m *AccessList) String() string { return proto.CompactTextString(m) }
+func (*AccessList) ProtoMessage()    {}
+func (*AccessList) Descriptor() ([]byte, []int) {
+	return fileDescriptor_58b4a54be18c47e6, []int{1}
+}
+
+func (m *AccessList) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AccessList.Unmarshal(m, b)
+}
+func (m *AccessList) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AccessList.Marshal(b, m, deterministic)
+}
+func (m *AccessList) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AccessList.Merge(m, src)
+}
+func (m *AccessList) XXX_Size() int {
+	return xxx_messageInfo_AccessList.Size(m)
+}
+func (m *AccessList) XXX_DiscardUnknown() {
+	xxx_messageInfo_AccessList.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AccessList proto.InternalMessageInfo
+
+func (m *AccessList) GetAllow() []string {
+	if m != nil {
+		return m.Allow
+	}
+	return nil
+}
+
+func (m *AccessList) GetDeny() []string {
+	if m != nil {
+		return m.Deny
+	}
+	return nil
+}
+
+type UpdateAccessListRequest struct {
+	Name                 string      `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	AccessList           *AccessList `protobuf:"bytes,2,opt,name=access_list,json=accessList,proto3" json:"access_list,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}    `json:"-"`
+	XXX_unrecognized     []byte      `json:"-"`
+	XXX_sizecache        int32       `json:"-"`
+}
+
+func (m *UpdateAccessListRequest) Reset()         { *m = UpdateAccessListRequest{} }
+func (m *UpdateAccessListRequest) String() string { return proto.CompactTextString(m) }
+func (*UpdateAccessListRequest) ProtoMessage()    {}
+func (*UpdateAccessListRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_58b4a54be18c47e6, []int{2}
+}
+
+func (m *UpdateAccessListRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_UpdateAccessListRequest.Unmarshal(m, b)
+}
+func (m *UpdateAccessListRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_UpdateAccessListRequest.Marshal(b, m, deterministic)
+}
+func (m *UpdateAccessListRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_UpdateAccessListRequest.Merge(m, src)
+}
+func (m *UpdateAccessListRequest) XXX_Size() int {
+	return xxx_messageInfo_UpdateAccessListRequest.Size(m)
+}
+func (m *UpdateAccessListRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_UpdateAccessListRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_UpdateAccessListRequest proto.InternalMessageInfo
+
+func (m *UpdateAccessListRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *UpdateAccessListRequest) GetAccessList() *AccessList {
+	if m != nil {
+		return m.AccessList
+	}
+	return nil
+}
+
 type ListClientsRequest struct {
 	PageToken            string   `protobuf:"bytes,1,opt,name=page_token,json=pageToken,proto3" json:"page_token,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -115,7 +329,7 @@ func (m *ListClientsRequest) Reset()         { *m = ListClientsRequest{} }
 func (m *ListClientsRequest) String() string { return proto.CompactTextString(m) }
 func (*ListClientsRequest) ProtoMessage()    {}
 func (*ListClientsRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_58b4a54be18c47e6, []int{1}
+	return fileDescriptor_58b4a54be18c47e6, []int{3}
 }
 
 func (m *ListClientsRequest) XXX_Unmarshal(b []byte) error {
@@ -156,7 +370,7 @@ func (m *ListClientsResponse) Reset()         { *m = ListClientsResponse{} }
 func (m *ListClientsResponse) String() string { return proto.CompactTextString(m) }
 func (*ListClientsResponse) ProtoMessage()    {}
 func (*ListClientsResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_58b4a54be18c47e6, []int{2}
+	return fileDescriptor_58b4a54be18c47e6, []int{4}
 }
 
 func (m *ListClientsResponse) XXX_Unmarshal(b []byte) error {
@@ -210,7 +424,7 @@ func (m *ListBackendServiceUsersRequest) Reset()         { *m = ListBackendServi
 func (m *ListBackendServiceUsersRequest) String() string { return proto.CompactTextString(m) }
 func (*ListBackendServiceUsersRequest) ProtoMessage()    {}
 func (*ListBackendServiceUsersRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_58b4a54be18c47e6, []int{3}
+	return fileDescriptor_58b4a54be18c47e6, []int{5}
 }
 
 func (m *ListBackendServiceUsersRequest) XXX_Unmarshal(b []byte) error {
@@ -258,7 +472,7 @@ func (m *ListBackendServiceUsersResponse) Reset()         { *m = ListBackendServ
 func (m *ListBackendServiceUsersResponse) String() string { return proto.CompactTextString(m) }
 func (*ListBackendServiceUsersResponse) ProtoMessage()    {}
 func (*ListBackendServiceUsersResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_58b4a54be18c47e6, []int{4}
+	return fileDescriptor_58b4a54be18c47e6, []int{6}
 }
 
 func (m *ListBackendServiceUsersResponse) XXX_Unmarshal(b []byte) error {
@@ -301,12 +515,32 @@ func (m *ListBackendServiceUsersResponse) GetTotalCount() int32 {
 }
 
 type Client struct {
-	Name                 string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
-	Token                string   `protobuf:"bytes,2,opt,name=token,proto3" json:"token,omitempty"`
-	DisplayName          string   `protobuf:"bytes,3,opt,name=display_name,json=displayName,proto3" json:"display_name,omitempty"`
-	InternalAddress      string   `protobuf:"bytes,4,opt,name=internal_address,json=internalAddress,proto3" json:"internal_address,omitempty"`
-	SharePublicAddr      bool     `protobuf:"varint,5,opt,name=share_public_addr,json=sharePublicAddr,proto3" json:"share_public_addr,omitempty"`
-	PublicAddress        string   `protobuf:"bytes,6,opt,name=public_address,json=publicAddress,proto3" json:"public_address,omitempty"`
+	Name            string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Token           string `protobuf:"bytes,2,opt,name=token,proto3" json:"token,omitempty"`
+	DisplayName     string `protobuf:"bytes,3,opt,name=display_name,json=displayName,proto3" json:"display_name,omitempty"`
+	InternalAddress string `protobuf:"bytes,4,opt,name=internal_address,json=internalAddress,proto3" json:"internal_address,omitempty"`
+	SharePublicAddr bool   `protobuf:"varint,5,opt,name=share_public_addr,json=sharePublicAddr,proto3" json:"share_public_addr,omitempty"`
+	PublicAddress   string `protobuf:"bytes,6,opt,name=public_address,json=publicAddress,proto3" json:"public_address,omitempty"`
+	// secret must be sent with token when connecting to internal address.
+	Secret string `protobuf:"bytes,7,opt,name=secret,proto3" json:"secret,omitempty"`
+	// internal address speaks tls.
+	InternalTls bool `protobuf:"varint,8,opt,name=internal_tls,json=internalTls,proto3" json:"internal_tls,omitempty"`
+	// lifetime bytes of all backend service users.
+	BytesIn  uint64 `protobuf:"varint,9,opt,name=bytes_in,json=bytesIn,proto3" json:"bytes_in,omitempty"`
+	BytesOut uint64 `protobuf:"varint,10,opt,name=bytes_out,json=bytesOut,proto3" json:"bytes_out,omitempty"`
+	// backend service users rejected by limits.
+	RejectedUsers uint64 `protobuf:"varint,11,opt,name=rejected_users,json=rejectedUsers,proto3" json:"rejected_users,omitempty"`
+	// address of the backend service user, only set with token.
+	UserAddress string  `protobuf:"bytes,12,opt,name=user_address,json=userAddress,proto3" json:"user_address,omitempty"`
+	Health      *Health `protobuf:"bytes,13,opt,name=health,proto3" json:"health,omitempty"`
+	// backend service users closed because they are not claimed in pair timeout.
+	UnclaimedUsers uint64 `protobuf:"varint,14,opt,name=unclaimed_users,json=unclaimedUsers,proto3" json:"unclaimed_users,omitempty"`
+	// the client does not accept backend service users and will be closed
+	// when existing users are gone.
+	Draining bool `protobuf:"varint,15,opt,name=draining,proto3" json:"draining,omitempty"`
+	// session_id resumes the client after the stream breaks,
+	// only set in the first message of the stream.
+	SessionId            string   `protobuf:"bytes,16,opt,name=session_id,json=sessionId,proto3" json:"session_id,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -316,7 +550,7 @@ func (m *Client) Reset()         { *m = Client{} }
 func (m *Client) String() string { return proto.CompactTextString(m) }
 func (*Client) ProtoMessage()    {}
 func (*Client) Descriptor() ([]byte, []int) {
-	return fileDescriptor_58b4a54be18c47e6, []int{5}
+	return fileDescriptor_58b4a54be18c47e6, []int{7}
 }
 
 func (m *Client) XXX_Unmarshal(b []byte) error {
@@ -379,10 +613,368 @@ func (m *Client) GetPublicAddress() string {
 	return ""
 }
 
+func (m *Client) GetSecret() string {
+	if m != nil {
+		return m.Secret
+	}
+	return ""
+}
+
+func (m *Client) GetInternalTls() bool {
+	if m != nil {
+		return m.InternalTls
+	}
+	return false
+}
+
+func (m *Client) GetBytesIn() uint64 {
+	if m != nil {
+		return m.BytesIn
+	}
+	return 0
+}
+
+func (m *Client) GetBytesOut() uint64 {
+	if m != nil {
+		return m.BytesOut
+	}
+	return 0
+}
+
+func (m *Client) GetRejectedUsers() uint64 {
+	if m != nil {
+		return m.RejectedUsers
+	}
+	return 0
+}
+
+func (m *Client) GetUserAddress() string {
+	if m != nil {
+		return m.UserAddress
+	}
+	return ""
+}
+
+func (m *Client) GetHealth() *Health {
+	if m != nil {
+		return m.Health
+	}
+	return nil
+}
+
+func (m *Client) GetUnclaimedUsers() uint64 {
+	if m != nil {
+		return m.UnclaimedUsers
+	}
+	return 0
+}
+
+func (m *Client) GetDraining() bool {
+	if m != nil {
+		return m.Draining
+	}
+	return false
+}
+
+func (m *Client) GetSessionId() string {
+	if m != nil {
+		return m.SessionId
+	}
+	return ""
+}
+
+type Health struct {
+	Healthy bool `protobuf:"varint,1,opt,name=healthy,proto3" json:"healthy,omitempty"`
+	// reason of unhealthy.
+	Detail               string   `protobuf:"bytes,2,opt,name=detail,proto3" json:"detail,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Health) Reset()         { *m = Health{} }
+func (m *Health) String() string { return proto.CompactTextString(m) }
+func (*Health) ProtoMessage()    {}
+func (*Health) Descriptor() ([]byte, []int) {
+	return fileDescriptor_58b4a54be18c47e6, []int{8}
+}
+
+func (m *Health) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Health.Unmarshal(m, b)
+}
+func (m *Health) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Health.Marshal(b, m, deterministic)
+}
+func (m *Health) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Health.Merge(m, src)
+}
+func (m *Health) XXX_Size() int {
+	return xxx_messageInfo_Health.Size(m)
+}
+func (m *Health) XXX_DiscardUnknown() {
+	xxx_messageInfo_Health.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Health proto.InternalMessageInfo
+
+func (m *Health) GetHealthy() bool {
+	if m != nil {
+		return m.Healthy
+	}
+	return false
+}
+
+func (m *Health) GetDetail() string {
+	if m != nil {
+		return m.Detail
+	}
+	return ""
+}
+
+type ReportDialFailureRequest struct {
+	Name                 string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Token                string   `protobuf:"bytes,2,opt,name=token,proto3" json:"token,omitempty"`
+	Secret               string   `protobuf:"bytes,3,opt,name=secret,proto3" json:"secret,omitempty"`
+	Error                string   `protobuf:"bytes,4,opt,name=error,proto3" json:"error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ReportDialFailureRequest) Reset()         { *m = ReportDialFailureRequest{} }
+func (m *ReportDialFailureRequest) String() string { return proto.CompactTextString(m) }
+func (*ReportDialFailureRequest) ProtoMessage()    {}
+func (*ReportDialFailureRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_58b4a54be18c47e6, []int{9}
+}
+
+func (m *ReportDialFailureRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ReportDialFailureRequest.Unmarshal(m, b)
+}
+func (m *ReportDialFailureRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ReportDialFailureRequest.Marshal(b, m, deterministic)
+}
+func (m *ReportDialFailureRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ReportDialFailureRequest.Merge(m, src)
+}
+func (m *ReportDialFailureRequest) XXX_Size() int {
+	return xxx_messageInfo_ReportDialFailureRequest.Size(m)
+}
+func (m *ReportDialFailureRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ReportDialFailureRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ReportDialFailureRequest proto.InternalMessageInfo
+
+func (m *ReportDialFailureRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *ReportDialFailureRequest) GetToken() string {
+	if m != nil {
+		return m.Token
+	}
+	return ""
+}
+
+func (m *ReportDialFailureRequest) GetSecret() string {
+	if m != nil {
+		return m.Secret
+	}
+	return ""
+}
+
+func (m *ReportDialFailureRequest) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+type ReportDialFailureResponse struct {
+	// the user is handed to another client.
+	Retried              bool     `protobuf:"varint,1,opt,name=retried,proto3" json:"retried,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ReportDialFailureResponse) Reset()         { *m = ReportDialFailureResponse{} }
+func (m *ReportDialFailureResponse) String() string { return proto.CompactTextString(m) }
+func (*ReportDialFailureResponse) ProtoMessage()    {}
+func (*ReportDialFailureResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_58b4a54be18c47e6, []int{10}
+}
+
+func (m *ReportDialFailureResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ReportDialFailureResponse.Unmarshal(m, b)
+}
+func (m *ReportDialFailureResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ReportDialFailureResponse.Marshal(b, m, deterministic)
+}
+func (m *ReportDialFailureResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ReportDialFailureResponse.Merge(m, src)
+}
+func (m *ReportDialFailureResponse) XXX_Size() int {
+	return xxx_messageInfo_ReportDialFailureResponse.Size(m)
+}
+func (m *ReportDialFailureResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ReportDialFailureResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ReportDialFailureResponse proto.InternalMessageInfo
+
+func (m *ReportDialFailureResponse) GetRetried() bool {
+	if m != nil {
+		return m.Retried
+	}
+	return false
+}
+
+type DrainClientRequest struct {
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// in seconds, server default is used if 0.
+	DrainTimeout         int32    `protobuf:"varint,2,opt,name=drain_timeout,json=drainTimeout,proto3" json:"drain_timeout,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DrainClientRequest) Reset()         { *m = DrainClientRequest{} }
+func (m *DrainClientRequest) String() string { return proto.CompactTextString(m) }
+func (*DrainClientRequest) ProtoMessage()    {}
+func (*DrainClientRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_58b4a54be18c47e6, []int{11}
+}
+
+func (m *DrainClientRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DrainClientRequest.Unmarshal(m, b)
+}
+func (m *DrainClientRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DrainClientRequest.Marshal(b, m, deterministic)
+}
+func (m *DrainClientRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DrainClientRequest.Merge(m, src)
+}
+func (m *DrainClientRequest) XXX_Size() int {
+	return xxx_messageInfo_DrainClientRequest.Size(m)
+}
+func (m *DrainClientRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_DrainClientRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DrainClientRequest proto.InternalMessageInfo
+
+func (m *DrainClientRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *DrainClientRequest) GetDrainTimeout() int32 {
+	if m != nil {
+		return m.DrainTimeout
+	}
+	return 0
+}
+
+type DrainClientResponse struct {
+	// backend service users still being served.
+	Users                int64    `protobuf:"varint,1,opt,name=users,proto3" json:"users,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DrainClientResponse) Reset()         { *m = DrainClientResponse{} }
+func (m *DrainClientResponse) String() string { return proto.CompactTextString(m) }
+func (*DrainClientResponse) ProtoMessage()    {}
+func (*DrainClientResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_58b4a54be18c47e6, []int{12}
+}
+
+func (m *DrainClientResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DrainClientResponse.Unmarshal(m, b)
+}
+func (m *DrainClientResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DrainClientResponse.Marshal(b, m, deterministic)
+}
+func (m *DrainClientResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DrainClientResponse.Merge(m, src)
+}
+func (m *DrainClientResponse) XXX_Size() int {
+	return xxx_messageInfo_DrainClientResponse.Size(m)
+}
+func (m *DrainClientResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_DrainClientResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DrainClientResponse proto.InternalMessageInfo
+
+func (m *DrainClientResponse) GetUsers() int64 {
+	if m != nil {
+		return m.Users
+	}
+	return 0
+}
+
+type ReportHealthRequest struct {
+	Name                 string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Health               *Health  `protobuf:"bytes,2,opt,name=health,proto3" json:"health,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ReportHealthRequest) Reset()         { *m = ReportHealthRequest{} }
+func (m *ReportHealthRequest) String() string { return proto.CompactTextString(m) }
+func (*ReportHealthRequest) ProtoMessage()    {}
+func (*ReportHealthRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_58b4a54be18c47e6, []int{13}
+}
+
+func (m *ReportHealthRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ReportHealthRequest.Unmarshal(m, b)
+}
+func (m *ReportHealthRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ReportHealthRequest.Marshal(b, m, deterministic)
+}
+func (m *ReportHealthRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ReportHealthRequest.Merge(m, src)
+}
+func (m *ReportHealthRequest) XXX_Size() int {
+	return xxx_messageInfo_ReportHealthRequest.Size(m)
+}
+func (m *ReportHealthRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ReportHealthRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ReportHealthRequest proto.InternalMessageInfo
+
+func (m *ReportHealthRequest) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *ReportHealthRequest) GetHealth() *Health {
+	if m != nil {
+		return m.Health
+	}
+	return nil
+}
+
 type BackendServiceUser struct {
 	UserAddr             string   `protobuf:"bytes,1,opt,name=user_addr,json=userAddr,proto3" json:"user_addr,omitempty"`
 	SpeedIn              float64  `protobuf:"fixed64,2,opt,name=speed_in,json=speedIn,proto3" json:"speed_in,omitempty"`
 	SpeedOut             float64  `protobuf:"fixed64,3,opt,name=speed_out,json=speedOut,proto3" json:"speed_out,omitempty"`
+	BytesIn              uint64   `protobuf:"varint,4,opt,name=bytes_in,json=bytesIn,proto3" json:"bytes_in,omitempty"`
+	BytesOut             uint64   `protobuf:"varint,5,opt,name=bytes_out,json=bytesOut,proto3" json:"bytes_out,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -392,7 +984,7 @@ func (m *BackendServiceUser) Reset()         { *m = BackendServiceUser{} }
 func (m *BackendServiceUser) String() string { return proto.CompactTextString(m) }
 func (*BackendServiceUser) ProtoMessage()    {}
 func (*BackendServiceUser) Descriptor() ([]byte, []int) {
-	return fileDescriptor_58b4a54be18c47e6, []int{6}
+	return fileDescriptor_58b4a54be18c47e6, []int{14}
 }
 
 func (m *BackendServiceUser) XXX_Unmarshal(b []byte) error {
@@ -434,6 +1026,20 @@ func (m *BackendServiceUser) GetSpeedOut() float64 {
 	return 0
 }
 
+func (m *BackendServiceUser) GetBytesIn() uint64 {
+	if m != nil {
+		return m.BytesIn
+	}
+	return 0
+}
+
+func (m *BackendServiceUser) GetBytesOut() uint64 {
+	if m != nil {
+		return m.BytesOut
+	}
+	return 0
+}
+
 type StartInternalServiceRequest struct {
 	ServiceName          string   `protobuf:"bytes,1,opt,name=service_name,json=serviceName,proto3" json:"service_name,omitempty"`
 	PubPort              int32    `protobuf:"varint,2,opt,name=pub_port,json=pubPort,proto3" json:"pub_port,omitempty"`
@@ -446,7 +1052,7 @@ func (m *StartInternalServiceRequest) Reset()         { *m = StartInternalServic
 func (m *StartInternalServiceRequest) String() string { return proto.CompactTextString(m) }
 func (*StartInternalServiceRequest) ProtoMessage()    {}
 func (*StartInternalServiceRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_58b4a54be18c47e6, []int{7}
+	return fileDescriptor_58b4a54be18c47e6, []int{15}
 }
 
 func (m *StartInternalServiceRequest) XXX_Unmarshal(b []byte) error {
@@ -492,7 +1098,7 @@ func (m *ListInternalServiceRequest) Reset()         { *m = ListInternalServiceR
 func (m *ListInternalServiceRequest) String() string { return proto.CompactTextString(m) }
 func (*ListInternalServiceRequest) ProtoMessage()    {}
 func (*ListInternalServiceRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_58b4a54be18c47e6, []int{8}
+	return fileDescriptor_58b4a54be18c47e6, []int{16}
 }
 
 func (m *ListInternalServiceRequest) XXX_Unmarshal(b []byte) error {
@@ -533,7 +1139,7 @@ func (m *ListInternalServiceResponse) Reset()         { *m = ListInternalService
 func (m *ListInternalServiceResponse) String() string { return proto.CompactTextString(m) }
 func (*ListInternalServiceResponse) ProtoMessage()    {}
 func (*ListInternalServiceResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_58b4a54be18c47e6, []int{9}
+	return fileDescriptor_58b4a54be18c47e6, []int{17}
 }
 
 func (m *ListInternalServiceResponse) XXX_Unmarshal(b []byte) error {
@@ -575,6 +1181,45 @@ func (m *ListInternalServiceResponse) GetTotalCount() int32 {
 	return 0
 }
 
+type TunnelData struct {
+	Data                 []byte   `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *TunnelData) Reset()         { *m = TunnelData{} }
+func (m *TunnelData) String() string { return proto.CompactTextString(m) }
+func (*TunnelData) ProtoMessage()    {}
+func (*TunnelData) Descriptor() ([]byte, []int) {
+	return fileDescriptor_58b4a54be18c47e6, []int{18}
+}
+
+func (m *TunnelData) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TunnelData.Unmarshal(m, b)
+}
+func (m *TunnelData) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TunnelData.Marshal(b, m, deterministic)
+}
+func (m *TunnelData) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TunnelData.Merge(m, src)
+}
+func (m *TunnelData) XXX_Size() int {
+	return xxx_messageInfo_TunnelData.Size(m)
+}
+func (m *TunnelData) XXX_DiscardUnknown() {
+	xxx_messageInfo_TunnelData.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_TunnelData proto.InternalMessageInfo
+
+func (m *TunnelData) GetData() []byte {
+	if m != nil {
+		return m.Data
+	}
+	return nil
+}
+
 type InternalService struct {
 	Name                 string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
 	Addr                 string   `protobuf:"bytes,2,opt,name=addr,proto3" json:"addr,omitempty"`
@@ -588,7 +1233,7 @@ func (m *InternalService) Reset()         { *m = InternalService{} }
 func (m *InternalService) String() string { return proto.CompactTextString(m) }
 func (*InternalService) ProtoMessage()    {}
 func (*InternalService) Descriptor() ([]byte, []int) {
-	return fileDescriptor_58b4a54be18c47e6, []int{10}
+	return fileDescriptor_58b4a54be18c47e6, []int{19}
 }
 
 func (m *InternalService) XXX_Unmarshal(b []byte) error {
@@ -632,71 +1277,124 @@ func (m *InternalService) GetServiceName() string {
 
 func init() {
 	proto.RegisterType((*CreateClientRequest)(nil), "api.CreateClientRequest")
+	proto.RegisterType((*AccessList)(nil), "api.AccessList")
+	proto.RegisterType((*UpdateAccessListRequest)(nil), "api.UpdateAccessListRequest")
 	proto.RegisterType((*ListClientsRequest)(nil), "api.ListClientsRequest")
 	proto.RegisterType((*ListClientsResponse)(nil), "api.ListClientsResponse")
 	proto.RegisterType((*ListBackendServiceUsersRequest)(nil), "api.ListBackendServiceUsersRequest")
 	proto.RegisterType((*ListBackendServiceUsersResponse)(nil), "api.ListBackendServiceUsersResponse")
 	proto.RegisterType((*Client)(nil), "api.Client")
+	proto.RegisterType((*Health)(nil), "api.Health")
+	proto.RegisterType((*ReportDialFailureRequest)(nil), "api.ReportDialFailureRequest")
+	proto.RegisterType((*ReportDialFailureResponse)(nil), "api.ReportDialFailureResponse")
+	proto.RegisterType((*DrainClientRequest)(nil), "api.DrainClientRequest")
+	proto.RegisterType((*DrainClientResponse)(nil), "api.DrainClientResponse")
+	proto.RegisterType((*ReportHealthRequest)(nil), "api.ReportHealthRequest")
 	proto.RegisterType((*BackendServiceUser)(nil), "api.BackendServiceUser")
 	proto.RegisterType((*StartInternalServiceRequest)(nil), "api.StartInternalServiceRequest")
 	proto.RegisterType((*ListInternalServiceRequest)(nil), "api.ListInternalServiceRequest")
 	proto.RegisterType((*ListInternalServiceResponse)(nil), "api.ListInternalServiceResponse")
+	proto.RegisterType((*TunnelData)(nil), "api.TunnelData")
 	proto.RegisterType((*InternalService)(nil), "api.InternalService")
 }
 
 func init() { proto.RegisterFile("proto/proxy.proto", fileDescriptor_58b4a54be18c47e6) }
 
 var fileDescriptor_58b4a54be18c47e6 = []byte{
-	// 783 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x55, 0xdd, 0x4e, 0xdb, 0x48,
-	0x18, 0xc5, 0x09, 0x09, 0xc9, 0x97, 0x40, 0x96, 0x21, 0x5a, 0x42, 0xd0, 0x6e, 0x8c, 0xb3, 0xac,
-	0x02, 0x12, 0x09, 0x82, 0xbd, 0x59, 0xed, 0x15, 0xc9, 0x15, 0x12, 0xda, 0x8d, 0xcc, 0xae, 0x56,
-	0xa2, 0x95, 0xa2, 0x89, 0x3d, 0x04, 0x0b, 0xc7, 0x63, 0x66, 0xc6, 0x14, 0x1e, 0xa0, 0x6f, 0xd0,
-	0x8b, 0xf6, 0xae, 0x37, 0x7d, 0xa2, 0x3e, 0x40, 0xa5, 0x3e, 0x49, 0x35, 0x33, 0xb6, 0x6b, 0xf2,
-	0x43, 0x6f, 0xb8, 0xf3, 0x9c, 0x39, 0xf3, 0x7d, 0x73, 0x4e, 0xce, 0x7c, 0x81, 0xcd, 0x90, 0x51,
-	0x41, 0x7b, 0x21, 0xa3, 0x0f, 0x8f, 0x5d, 0xf5, 0x8d, 0xf2, 0x38, 0xf4, 0x9a, 0xed, 0x09, 0x3d,
-	0x52, 0xcb, 0xa3, 0x7b, 0xec, 0x7b, 0x2e, 0x16, 0x94, 0xf1, 0x5e, 0xfa, 0xa9, 0x99, 0xd6, 0xbb,
-	0x1c, 0x6c, 0x0d, 0x18, 0xc1, 0x82, 0x0c, 0x7c, 0x8f, 0x04, 0xc2, 0x26, 0x77, 0x11, 0xe1, 0x02,
-	0x5d, 0x40, 0xd5, 0xf5, 0x78, 0xe8, 0xe3, 0xc7, 0x51, 0x80, 0xa7, 0xa4, 0x61, 0x98, 0x46, 0xa7,
-	0xdc, 0x3f, 0xf8, 0xfa, 0xa5, 0xb5, 0x7f, 0x68, 0x4e, 0xf1, 0x83, 0xe9, 0x93, 0x60, 0x22, 0x6e,
-	0x4c, 0x7a, 0x6d, 0xc6, 0x3c, 0x53, 0xf2, 0x4c, 0x8f, 0x9b, 0x27, 0xc7, 0x1f, 0x8d, 0xba, 0x5d,
-	0x89, 0xe1, 0xbf, 0xf1, 0x94, 0xa0, 0x36, 0xac, 0x7b, 0x81, 0x20, 0x2c, 0xc0, 0xfe, 0x28, 0xa4,
-	0x4c, 0x34, 0x72, 0xa6, 0xd1, 0x29, 0xd8, 0xd5, 0x04, 0x1c, 0x52, 0x26, 0x50, 0x0b, 0x2a, 0x61,
-	0x34, 0xf6, 0x3d, 0x47, 0x53, 0xf2, 0x8a, 0x02, 0x1a, 0x52, 0x84, 0x43, 0xd8, 0xe4, 0x37, 0x98,
-	0x91, 0x51, 0x4c, 0xc3, 0xae, 0xcb, 0x1a, 0xab, 0xa6, 0xd1, 0x29, 0xd9, 0x35, 0xb5, 0x31, 0x54,
-	0xf8, 0x99, 0xeb, 0x32, 0xd4, 0x84, 0x92, 0x12, 0xe8, 0x50, 0xbf, 0x51, 0x90, 0x77, 0xb7, 0xd3,
-	0x35, 0xda, 0x83, 0xea, 0x18, 0x3b, 0xb7, 0x24, 0x70, 0x75, 0xa7, 0xa2, 0xea, 0x54, 0x89, 0x31,
-	0xd9, 0xca, 0x3a, 0x05, 0x74, 0xe1, 0x71, 0xa1, 0x3d, 0xe1, 0x89, 0x29, 0xbf, 0x00, 0x84, 0x78,
-	0x42, 0x46, 0x82, 0xde, 0x92, 0x40, 0x5b, 0x62, 0x97, 0x25, 0xf2, 0xaf, 0x04, 0xac, 0xb7, 0x06,
-	0x6c, 0x3d, 0x39, 0xc5, 0x43, 0x1a, 0x70, 0x82, 0xf6, 0x61, 0xcd, 0xd1, 0x50, 0xc3, 0x30, 0xf3,
-	0x9d, 0xca, 0x49, 0xa5, 0x8b, 0x43, 0xaf, 0x1b, 0x1b, 0x9e, 0xec, 0xa1, 0xdf, 0xa1, 0x16, 0x90,
-	0x07, 0x31, 0xca, 0xb4, 0xc8, 0xa9, 0x16, 0xeb, 0x12, 0x1e, 0x26, 0x6d, 0xa4, 0x4f, 0x82, 0x0a,
-	0xec, 0x8f, 0x1c, 0x1a, 0x05, 0xa9, 0x4f, 0x0a, 0x1a, 0x48, 0xc4, 0xfa, 0x1f, 0x7e, 0x95, 0xd7,
-	0xe8, 0x6b, 0x3d, 0x97, 0x84, 0xdd, 0x7b, 0x0e, 0xf9, 0x8f, 0x13, 0x96, 0x0a, 0xf9, 0x19, 0x8a,
-	0x21, 0x66, 0x24, 0x10, 0xb1, 0x88, 0x78, 0x35, 0x23, 0x30, 0x37, 0x2b, 0xf0, 0x83, 0x01, 0xad,
-	0xa5, 0x95, 0x63, 0xb1, 0x47, 0x50, 0x88, 0x24, 0x10, 0x4b, 0xdd, 0x56, 0x52, 0xe7, 0x0f, 0xd8,
-	0x9a, 0xf5, 0x72, 0xa2, 0x3f, 0x1b, 0x50, 0xd4, 0x8e, 0x22, 0x04, 0xab, 0xdf, 0x33, 0x6b, 0xab,
-	0x6f, 0x54, 0x87, 0x42, 0xb6, 0xba, 0x5e, 0xc8, 0x24, 0x3c, 0x49, 0x79, 0x5e, 0x6d, 0x3e, 0x89,
-	0xee, 0x01, 0xfc, 0x94, 0x46, 0x57, 0x06, 0x8e, 0x70, 0xae, 0x32, 0x57, 0xb6, 0x6b, 0x09, 0x7e,
-	0xa6, 0xe1, 0xc5, 0xf9, 0x2c, 0x2c, 0xce, 0xe7, 0x3e, 0x6c, 0x64, 0x58, 0xb2, 0x68, 0x51, 0xcb,
-	0x0e, 0x53, 0x0e, 0xe1, 0xdc, 0xf2, 0x00, 0xcd, 0x7b, 0x87, 0x76, 0xa1, 0x2c, 0xdd, 0xd3, 0x0d,
-	0xb4, 0xca, 0x92, 0x04, 0x54, 0xe5, 0x1d, 0x28, 0xf1, 0x90, 0x10, 0x77, 0xe4, 0x69, 0xb1, 0x86,
-	0xbd, 0xa6, 0xd6, 0xe7, 0x81, 0x3c, 0xa7, 0xb7, 0x68, 0xa4, 0x2d, 0x34, 0x6c, 0xcd, 0xfd, 0x27,
-	0x12, 0xd6, 0x2b, 0xd8, 0xbd, 0x14, 0x98, 0x89, 0xf3, 0x58, 0x55, 0xdc, 0x30, 0x89, 0xcc, 0x1e,
-	0x54, 0xb9, 0x46, 0x32, 0x03, 0xc1, 0xae, 0xc4, 0x98, 0xb2, 0x6a, 0x07, 0x4a, 0x61, 0x34, 0xce,
-	0x3e, 0xf0, 0xb5, 0x30, 0x1a, 0xab, 0xf7, 0xf4, 0x17, 0x34, 0x65, 0x70, 0x96, 0xd4, 0xfe, 0xc1,
-	0xbb, 0x7a, 0x6f, 0xc0, 0xee, 0xc2, 0xd3, 0x71, 0xe4, 0x8e, 0xa1, 0x14, 0x5f, 0x23, 0x49, 0x5d,
-	0x5d, 0xa5, 0x6e, 0x96, 0x9f, 0xb2, 0x5e, 0x2e, 0x75, 0xaf, 0xa1, 0x36, 0xd3, 0x65, 0x61, 0xfa,
-	0x10, 0xac, 0xaa, 0xdf, 0x4a, 0x37, 0x51, 0xdf, 0x73, 0x86, 0xe6, 0xe7, 0x0c, 0x3d, 0xf9, 0x94,
-	0x87, 0x8d, 0x01, 0x0d, 0x04, 0xa3, 0x69, 0xf5, 0x3f, 0xa1, 0x9a, 0x1d, 0xd7, 0xa8, 0xa1, 0x47,
-	0xc9, 0xfc, 0x04, 0x6f, 0x66, 0x87, 0x8c, 0xb5, 0x72, 0x6c, 0xa0, 0x3e, 0x54, 0x32, 0xd3, 0x09,
-	0xe9, 0x97, 0x39, 0x3f, 0xe5, 0x9a, 0x8d, 0xf9, 0x0d, 0x6d, 0xb4, 0xb5, 0x82, 0xae, 0x61, 0x7b,
-	0xc9, 0x00, 0x40, 0xed, 0xf4, 0xd8, 0xf2, 0xc1, 0xd3, 0xfc, 0xed, 0x79, 0x52, 0xda, 0x67, 0x08,
-	0xf5, 0x45, 0x61, 0x44, 0xa6, 0x3a, 0xff, 0x4c, 0x4e, 0x9b, 0x0b, 0x7f, 0x7a, 0x6b, 0x05, 0x5d,
-	0xe9, 0xd9, 0x3c, 0x5b, 0xb0, 0x95, 0x5e, 0x68, 0x49, 0x3d, 0x73, 0x39, 0x21, 0xb9, 0x6d, 0xbf,
-	0x7d, 0xb5, 0x37, 0xf1, 0xc4, 0x4d, 0x34, 0xee, 0x3a, 0x74, 0xda, 0x7b, 0xe3, 0x05, 0x13, 0xff,
-	0xae, 0xe7, 0xff, 0xa1, 0xfe, 0x91, 0x7b, 0x9c, 0x39, 0x3d, 0x1c, 0x7a, 0xe3, 0xa2, 0xfa, 0xff,
-	0x39, 0xfd, 0x16, 0x00, 0x00, 0xff, 0xff, 0xdf, 0x25, 0xa4, 0x57, 0xaf, 0x07, 0x00, 0x00,
+	// 1487 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x57, 0xdb, 0x6e, 0xdb, 0x46,
+	0x13, 0x36, 0x6d, 0x4b, 0x96, 0x46, 0xb2, 0x64, 0xaf, 0x85, 0x84, 0x61, 0x0e, 0xa6, 0xe9, 0xf8,
+	0xff, 0x95, 0x04, 0xb6, 0x05, 0xa7, 0x07, 0xb4, 0xbd, 0x8a, 0x6d, 0x14, 0x35, 0x10, 0xb4, 0x06,
+	0xe3, 0xa0, 0x45, 0x5a, 0x94, 0x58, 0x91, 0x1b, 0x79, 0x9b, 0x15, 0xc9, 0x90, 0x4b, 0x47, 0x7e,
+	0x80, 0xde, 0xf4, 0x09, 0x9a, 0xbb, 0xf6, 0xc9, 0x02, 0xe4, 0x05, 0xfa, 0x08, 0x2d, 0xf6, 0x40,
+	0x9a, 0x3a, 0x39, 0xb9, 0xc8, 0x95, 0x38, 0xdf, 0x0c, 0x67, 0x66, 0x87, 0x33, 0xdf, 0x8e, 0x60,
+	0x3d, 0x4e, 0x22, 0x1e, 0xed, 0xc7, 0x49, 0x34, 0xba, 0xdc, 0x93, 0xcf, 0x68, 0x09, 0xc7, 0xd4,
+	0xda, 0x1e, 0x44, 0xbb, 0x52, 0xdc, 0xbd, 0xc0, 0x8c, 0x06, 0x98, 0x47, 0x49, 0xba, 0x5f, 0x3c,
+	0x2a, 0x4b, 0xe7, 0xdf, 0x0a, 0x6c, 0x1c, 0x25, 0x04, 0x73, 0x72, 0xc4, 0x28, 0x09, 0xb9, 0x4b,
+	0x5e, 0x67, 0x24, 0xe5, 0xe8, 0x29, 0x34, 0x03, 0x9a, 0xc6, 0x0c, 0x5f, 0x7a, 0x21, 0x1e, 0x12,
+	0xd3, 0xb0, 0x8d, 0x6e, 0xfd, 0xf0, 0xc1, 0xfb, 0x77, 0x9b, 0x3b, 0x0f, 0xed, 0x21, 0x1e, 0xd9,
+	0x8c, 0x84, 0x03, 0x7e, 0x6e, 0x47, 0x2f, 0x6d, 0x6d, 0x67, 0x0b, 0x3b, 0x9b, 0xa6, 0xf6, 0x41,
+	0xef, 0x2f, 0xa3, 0xe3, 0x36, 0x34, 0xfc, 0x3d, 0x1e, 0x12, 0xb4, 0x0d, 0xab, 0x34, 0xe4, 0x24,
+	0x09, 0x31, 0xf3, 0xe2, 0x28, 0xe1, 0xe6, 0xa2, 0x6d, 0x74, 0x2b, 0x6e, 0x33, 0x07, 0x4f, 0xa3,
+	0x84, 0xa3, 0x4d, 0x68, 0xc4, 0x59, 0x9f, 0x51, 0x5f, 0x99, 0x2c, 0x49, 0x13, 0x50, 0x90, 0x34,
+	0x78, 0x08, 0xeb, 0xe9, 0x39, 0x4e, 0x88, 0xa7, 0xcd, 0x70, 0x10, 0x24, 0xe6, 0xb2, 0x6d, 0x74,
+	0x6b, 0x6e, 0x5b, 0x2a, 0x4e, 0x25, 0xfe, 0x24, 0x08, 0x12, 0x64, 0x41, 0x4d, 0x1e, 0xd0, 0x8f,
+	0x98, 0x59, 0x11, 0xb9, 0xbb, 0x85, 0x8c, 0xb6, 0xa0, 0xd9, 0xc7, 0xfe, 0x2b, 0x12, 0x06, 0x2a,
+	0x52, 0x55, 0x46, 0x6a, 0x68, 0x4c, 0x86, 0xba, 0x03, 0xf5, 0x61, 0xc6, 0x38, 0x8d, 0x19, 0x19,
+	0x99, 0x2b, 0x32, 0xc4, 0x15, 0x80, 0x1c, 0x58, 0x4d, 0x30, 0x27, 0x1e, 0xa3, 0x43, 0xca, 0x3d,
+	0x1a, 0x9a, 0x35, 0xdb, 0xe8, 0x2e, 0xbb, 0x0d, 0x01, 0x3e, 0x15, 0xd8, 0x49, 0x88, 0xee, 0x43,
+	0xab, 0x64, 0x13, 0x65, 0xdc, 0xac, 0x4b, 0xa3, 0x66, 0x61, 0xf4, 0x43, 0xc6, 0xd1, 0x23, 0x40,
+	0x59, 0x4a, 0x12, 0x6f, 0xdc, 0x1d, 0x48, 0xcb, 0xb6, 0xd0, 0xb8, 0x25, 0x97, 0xbb, 0xb0, 0x31,
+	0x69, 0x2c, 0xfc, 0x36, 0xa4, 0xf5, 0xda, 0x98, 0xb5, 0xf0, 0x7d, 0x1b, 0xea, 0x43, 0x3c, 0xf2,
+	0x04, 0x9e, 0x9a, 0x4d, 0x79, 0xc6, 0xda, 0x10, 0x8f, 0x9e, 0x0b, 0x19, 0xf5, 0xa0, 0x81, 0x7d,
+	0x9f, 0xa4, 0xa9, 0xc7, 0x68, 0xca, 0xcd, 0x55, 0xdb, 0xe8, 0x36, 0x0e, 0xda, 0x7b, 0x38, 0xa6,
+	0x7b, 0x4f, 0x24, 0xfe, 0x94, 0xa6, 0xdc, 0x05, 0x5c, 0x3c, 0x0b, 0x77, 0xac, 0xef, 0xc5, 0x11,
+	0xa3, 0xfe, 0xa5, 0xd9, 0x52, 0x25, 0x65, 0xfd, 0x53, 0x29, 0xa3, 0x1b, 0x50, 0x7d, 0x43, 0xe8,
+	0xe0, 0x9c, 0x9b, 0x6d, 0x19, 0x48, 0x4b, 0xa2, 0xd4, 0x34, 0x60, 0xc4, 0xe3, 0x74, 0x48, 0x44,
+	0xae, 0x6b, 0xaa, 0xd4, 0x02, 0x3b, 0x53, 0x10, 0xea, 0x41, 0x47, 0xa4, 0x99, 0x92, 0x34, 0xa5,
+	0x51, 0xe8, 0x05, 0x59, 0x82, 0x39, 0x8d, 0x42, 0x73, 0x5d, 0x9a, 0xa2, 0x21, 0x1e, 0x3d, 0x53,
+	0xaa, 0x63, 0xad, 0x41, 0xbb, 0x80, 0x5e, 0x11, 0x12, 0x63, 0x46, 0x2f, 0x88, 0x27, 0x5b, 0xe8,
+	0x02, 0x33, 0x13, 0x49, 0xfb, 0xf5, 0x42, 0x73, 0xa2, 0x15, 0xe8, 0x2e, 0x40, 0xee, 0x9c, 0x06,
+	0xe6, 0x86, 0xcc, 0xbc, 0xae, 0x91, 0x93, 0xc0, 0xf9, 0x02, 0xe0, 0xea, 0xc4, 0xa8, 0x03, 0x15,
+	0xcc, 0x58, 0xf4, 0xc6, 0x34, 0xec, 0xa5, 0x6e, 0xdd, 0x55, 0x02, 0x42, 0xb0, 0x1c, 0x90, 0xf0,
+	0xd2, 0x5c, 0x94, 0xa0, 0x7c, 0x76, 0x06, 0x70, 0xf3, 0x79, 0x1c, 0x60, 0x4e, 0x4a, 0xf5, 0xd2,
+	0xc3, 0x63, 0xc1, 0x72, 0x69, 0x68, 0xaa, 0xef, 0xdf, 0x6d, 0x2e, 0xfe, 0x64, 0xb8, 0x12, 0x9b,
+	0x2c, 0xfc, 0xe2, 0x07, 0x0b, 0xef, 0x3c, 0x06, 0x24, 0x7e, 0xd5, 0x7c, 0xa6, 0x79, 0x8c, 0xbb,
+	0x00, 0x31, 0x1e, 0x10, 0x8f, 0x47, 0xaf, 0x48, 0xa8, 0x22, 0xb9, 0x75, 0x81, 0x9c, 0x09, 0xc0,
+	0xf9, 0xdd, 0x80, 0x8d, 0xb1, 0xb7, 0xd2, 0x38, 0x0a, 0x53, 0x82, 0x76, 0x60, 0xc5, 0x57, 0x90,
+	0x3c, 0x61, 0xe3, 0xa0, 0x21, 0x43, 0xeb, 0xe1, 0xcf, 0x75, 0xe8, 0x7f, 0xd0, 0x0e, 0xc9, 0x88,
+	0x7b, 0xa5, 0x10, 0x8b, 0x32, 0xc4, 0xaa, 0x80, 0x4f, 0xf3, 0x30, 0x62, 0x66, 0x79, 0xc4, 0x31,
+	0xf3, 0xfc, 0x28, 0x0b, 0x8b, 0x99, 0x95, 0xd0, 0x91, 0x40, 0x9c, 0x1f, 0xe1, 0x9e, 0x48, 0xe3,
+	0x50, 0xcd, 0xd6, 0x33, 0x92, 0x5c, 0x50, 0x9f, 0xc8, 0x16, 0xcc, 0x0f, 0x72, 0x03, 0xaa, 0x31,
+	0x4e, 0x48, 0xc8, 0xf5, 0x21, 0xb4, 0x34, 0x71, 0xc0, 0xc5, 0xc9, 0x03, 0xbe, 0x35, 0x60, 0x73,
+	0xae, 0x67, 0x7d, 0xd8, 0x5d, 0xa8, 0xa8, 0xee, 0x57, 0x47, 0xbd, 0x29, 0x8f, 0x3a, 0xfd, 0x82,
+	0xab, 0xac, 0x3e, 0xdd, 0xa1, 0xdf, 0x2e, 0x43, 0x55, 0x55, 0x54, 0x74, 0xce, 0x55, 0x2b, 0xe8,
+	0x16, 0xe8, 0x40, 0xa5, 0xec, 0x5d, 0x09, 0x62, 0x54, 0xc6, 0x18, 0x77, 0x49, 0x2a, 0xc7, 0x68,
+	0xf4, 0x01, 0xac, 0x15, 0x34, 0x2a, 0xc8, 0x8f, 0xa4, 0xa9, 0xe4, 0xbf, 0xba, 0xdb, 0xce, 0xf1,
+	0x27, 0x0a, 0x9e, 0xcd, 0x95, 0x95, 0xd9, 0x5c, 0xb9, 0x03, 0xad, 0x92, 0x95, 0x70, 0x5a, 0x55,
+	0xc7, 0x8e, 0x0b, 0x1b, 0xe1, 0xf2, 0x06, 0x54, 0x53, 0xe2, 0x27, 0x84, 0x4b, 0x42, 0xac, 0xbb,
+	0x5a, 0x92, 0x33, 0x9e, 0x67, 0xc5, 0x59, 0x2a, 0xc9, 0xb0, 0xe6, 0x36, 0x72, 0xec, 0x8c, 0xa5,
+	0xe8, 0x16, 0xd4, 0xfa, 0x97, 0x9c, 0xa4, 0x82, 0xdc, 0x14, 0x0d, 0xae, 0x48, 0xf9, 0x24, 0x14,
+	0xb4, 0xa2, 0x54, 0x82, 0x1e, 0x14, 0xf1, 0x29, 0x5b, 0x41, 0x61, 0x3b, 0xd0, 0x4a, 0xc8, 0x6f,
+	0xc4, 0xe7, 0x24, 0xd0, 0x3c, 0xa6, 0xc8, 0x6e, 0x35, 0x47, 0x15, 0x99, 0x6d, 0x41, 0x53, 0x68,
+	0x8b, 0xf4, 0x9b, 0xaa, 0x74, 0x02, 0xcb, 0x93, 0xdf, 0x86, 0xea, 0x39, 0xc1, 0x8c, 0x9f, 0x6b,
+	0xaa, 0x53, 0x6d, 0xff, 0x9d, 0x84, 0x5c, 0xad, 0x42, 0xff, 0x87, 0x76, 0x16, 0xfa, 0x0c, 0xd3,
+	0x61, 0x11, 0xaf, 0x25, 0xe3, 0xb5, 0x0a, 0x58, 0x05, 0xb4, 0xa0, 0x16, 0x24, 0x98, 0x86, 0x34,
+	0x1c, 0x48, 0xc2, 0xab, 0xb9, 0x85, 0x3c, 0x41, 0x37, 0x6b, 0x93, 0x74, 0xf3, 0x35, 0x54, 0x55,
+	0x54, 0x64, 0xc2, 0x8a, 0x8a, 0x7b, 0x29, 0xbb, 0xa3, 0xe6, 0xe6, 0xa2, 0xa8, 0x74, 0x40, 0x38,
+	0xa6, 0x4c, 0x77, 0x88, 0x96, 0x9c, 0x3f, 0x0c, 0x30, 0x5d, 0x22, 0xee, 0xac, 0x63, 0x8a, 0xd9,
+	0xb7, 0x98, 0xb2, 0x2c, 0x21, 0x1f, 0x43, 0x3a, 0x77, 0xc6, 0x3a, 0xae, 0x50, 0x2a, 0x10, 0xdd,
+	0x2b, 0x3e, 0xec, 0xd2, 0x98, 0x3a, 0xff, 0xc0, 0x1d, 0xa8, 0x90, 0x24, 0x89, 0x12, 0xdd, 0x6b,
+	0x4a, 0x70, 0x3e, 0x87, 0x5b, 0x33, 0x72, 0xd1, 0x93, 0x67, 0xc2, 0x4a, 0x42, 0x78, 0x42, 0x49,
+	0x90, 0x9f, 0x4d, 0x8b, 0xce, 0x73, 0x40, 0xc7, 0xa2, 0x54, 0xe3, 0xeb, 0xc6, 0x75, 0xc9, 0x6f,
+	0xc3, 0xaa, 0x2c, 0x6e, 0x71, 0x89, 0xe8, 0xe5, 0x41, 0x82, 0xfa, 0x16, 0x71, 0x1e, 0xc1, 0xc6,
+	0x98, 0x5b, 0x9d, 0x47, 0xe7, 0x8a, 0x01, 0x8c, 0xee, 0x92, 0x1e, 0x74, 0xe7, 0x57, 0xd8, 0x50,
+	0xa9, 0xeb, 0xef, 0xff, 0x11, 0x49, 0x3c, 0x2a, 0xfa, 0x67, 0x71, 0xaa, 0x7f, 0x94, 0xa9, 0x6d,
+	0xe4, 0x7d, 0xe4, 0xfc, 0x6d, 0x00, 0x9a, 0xa6, 0x19, 0xd1, 0xea, 0x45, 0x9b, 0x6a, 0x42, 0xa8,
+	0xe5, 0x3d, 0x2a, 0x46, 0x24, 0x8d, 0x09, 0x09, 0xc4, 0x88, 0x88, 0x10, 0x86, 0xbb, 0x22, 0x65,
+	0x35, 0x22, 0x4a, 0x15, 0x65, 0xea, 0x13, 0x19, 0xae, 0xb2, 0x15, 0x23, 0x52, 0x1e, 0xad, 0xe5,
+	0x6b, 0x46, 0xab, 0x32, 0x3e, 0x5a, 0xce, 0xcf, 0x70, 0xfb, 0x19, 0xc7, 0x09, 0x3f, 0xd1, 0x63,
+	0xaa, 0x13, 0xcd, 0x6b, 0xb1, 0x05, 0xcd, 0x54, 0x21, 0xa5, 0xfd, 0xcf, 0x6d, 0x68, 0x4c, 0xb2,
+	0xd1, 0x2d, 0xa8, 0xc5, 0x59, 0xbf, 0xbc, 0xcf, 0xad, 0xc4, 0x59, 0x5f, 0xac, 0x4f, 0xce, 0x37,
+	0x60, 0x09, 0x6e, 0x9e, 0xe3, 0xfb, 0x03, 0x57, 0xd7, 0x9f, 0x06, 0xdc, 0x9e, 0xf9, 0xb6, 0xfe,
+	0xa6, 0x3d, 0xa8, 0xe9, 0x34, 0x72, 0x62, 0xef, 0xc8, 0x8f, 0x31, 0x69, 0x5f, 0x58, 0x7d, 0x3a,
+	0x62, 0xb7, 0x01, 0xce, 0xb2, 0x30, 0x24, 0xec, 0x18, 0x73, 0x2c, 0xb7, 0x02, 0xcc, 0xb1, 0x3c,
+	0x41, 0xd3, 0x95, 0xcf, 0xce, 0x2f, 0xd0, 0x9e, 0xc8, 0x63, 0xe6, 0x15, 0x80, 0x60, 0x59, 0x76,
+	0x81, 0x4a, 0x43, 0x3e, 0x4f, 0x95, 0x7c, 0x69, 0xaa, 0xe4, 0x07, 0xff, 0x54, 0xa0, 0x75, 0x14,
+	0x85, 0x3c, 0x89, 0x0a, 0xef, 0x5f, 0x41, 0xb3, 0xbc, 0xbf, 0x23, 0x53, 0xdd, 0xe7, 0xd3, 0x2b,
+	0xbd, 0x55, 0xbe, 0xe9, 0x9d, 0x85, 0x9e, 0x81, 0x0e, 0xa1, 0x51, 0x5a, 0x11, 0x90, 0xba, 0x1e,
+	0xa7, 0x57, 0x0d, 0xcb, 0x9c, 0x56, 0xa8, 0x4f, 0xe1, 0x2c, 0xa0, 0x97, 0x70, 0x73, 0xce, 0x2d,
+	0x8c, 0xb6, 0x8b, 0xd7, 0xe6, 0xdf, 0xfe, 0xd6, 0xfd, 0xeb, 0x8d, 0x8a, 0x38, 0xa7, 0xd0, 0x99,
+	0xd5, 0xae, 0xc8, 0x96, 0xef, 0x5f, 0xd3, 0xc9, 0xd6, 0xcc, 0xe6, 0x70, 0x16, 0xd0, 0x0b, 0xb5,
+	0x20, 0x4d, 0x3a, 0xdc, 0x2c, 0x12, 0x9a, 0xe3, 0xcf, 0x9e, 0x6f, 0x50, 0x64, 0xdb, 0x83, 0xaa,
+	0xea, 0x13, 0xa4, 0x36, 0xbb, 0xab, 0xa6, 0xb1, 0x26, 0x01, 0x67, 0xa1, 0x6b, 0xf4, 0x0c, 0x74,
+	0x04, 0x6b, 0x93, 0xdb, 0x24, 0xba, 0x23, 0x4d, 0xe7, 0x2c, 0x99, 0xd6, 0xe4, 0xce, 0xe8, 0x2c,
+	0xa0, 0x2f, 0xa1, 0x59, 0xe6, 0x35, 0xdd, 0x0b, 0x33, 0xa8, 0xce, 0x2a, 0xd3, 0x97, 0xb3, 0x80,
+	0xce, 0x60, 0x7d, 0x8a, 0xcb, 0xd1, 0xdd, 0xd2, 0xdb, 0xd3, 0xf7, 0x8d, 0x75, 0x6f, 0x9e, 0xba,
+	0xa8, 0xc2, 0x21, 0x34, 0x4a, 0x9c, 0xac, 0xfb, 0x6b, 0x9a, 0xfc, 0x2d, 0x73, 0x5a, 0x91, 0xfb,
+	0x38, 0xdc, 0x7e, 0xb1, 0x35, 0xa0, 0xfc, 0x3c, 0xeb, 0xef, 0xf9, 0xd1, 0x70, 0xff, 0x0d, 0x0d,
+	0x07, 0xec, 0xf5, 0x3e, 0xfb, 0x4c, 0xfe, 0xd9, 0xdd, 0x4f, 0x13, 0x7f, 0x1f, 0xc7, 0xb4, 0x5f,
+	0x95, 0x7f, 0xed, 0x1e, 0xff, 0x37, 0x00, 0x7d, 0xf7, 0xd3, 0x97, 0x0a, 0x0f, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -716,6 +1414,20 @@ type ControlServiceClient interface {
 	ListBackendServiceUsers(ctx context.Context, in *ListBackendServiceUsersRequest, opts ...grpc.CallOption) (*ListBackendServiceUsersResponse, error)
 	StartInternalService(ctx context.Context, in *StartInternalServiceRequest, opts ...grpc.CallOption) (*InternalService, error)
 	ListInternalService(ctx context.Context, in *ListInternalServiceRequest, opts ...grpc.CallOption) (*ListInternalServiceResponse, error)
+	// Tunnel carries a multiplexed session for a client created with multiplex,
+	// client name is passed by l4proxy-client metadata.
+	Tunnel(ctx context.Context, opts ...grpc.CallOption) (ControlService_TunnelClient, error)
+	// UpdateAccessList replaces access list of a running client.
+	UpdateAccessList(ctx context.Context, in *UpdateAccessListRequest, opts ...grpc.CallOption) (*AccessList, error)
+	// ReportHealth reports health of the backend of a client, users are not
+	// handed to clients with unhealthy backend.
+	ReportHealth(ctx context.Context, in *ReportHealthRequest, opts ...grpc.CallOption) (*Health, error)
+	// ReportDialFailure reports that the backend service user of token can not be
+	// served, the user is retried on another client sharing the public port or closed.
+	ReportDialFailure(ctx context.Context, in *ReportDialFailureRequest, opts ...grpc.CallOption) (*ReportDialFailureResponse, error)
+	// DrainClient stops accepting backend service users of a client, existing users
+	// are served until they are gone or drain timeout, then the client is closed.
+	DrainClient(ctx context.Context, in *DrainClientRequest, opts ...grpc.CallOption) (*DrainClientResponse, error)
 }
 
 type controlServiceClient struct {
@@ -794,6 +1506,73 @@ func (c *controlServiceClient) ListInternalService(ctx context.Context, in *List
 	return out, nil
 }
 
+func (c *controlServiceClient) Tunnel(ctx context.Context, opts ...grpc.CallOption) (ControlService_TunnelClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_ControlService_serviceDesc.Streams[1], "/api.ControlService/Tunnel", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &controlServiceTunnelClient{stream}
+	return x, nil
+}
+
+type ControlService_TunnelClient interface {
+	Send(*TunnelData) error
+	Recv() (*TunnelData, error)
+	grpc.ClientStream
+}
+
+type controlServiceTunnelClient struct {
+	grpc.ClientStream
+}
+
+func (x *controlServiceTunnelClient) Send(m *TunnelData) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *controlServiceTunnelClient) Recv() (*TunnelData, error) {
+	m := new(TunnelData)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *controlServiceClient) UpdateAccessList(ctx context.Context, in *UpdateAccessListRequest, opts ...grpc.CallOption) (*AccessList, error) {
+	out := new(AccessList)
+	err := c.cc.Invoke(ctx, "/api.ControlService/UpdateAccessList", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlServiceClient) ReportHealth(ctx context.Context, in *ReportHealthRequest, opts ...grpc.CallOption) (*Health, error) {
+	out := new(Health)
+	err := c.cc.Invoke(ctx, "/api.ControlService/ReportHealth", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlServiceClient) ReportDialFailure(ctx context.Context, in *ReportDialFailureRequest, opts ...grpc.CallOption) (*ReportDialFailureResponse, error) {
+	out := new(ReportDialFailureResponse)
+	err := c.cc.Invoke(ctx, "/api.ControlService/ReportDialFailure", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *controlServiceClient) DrainClient(ctx context.Context, in *DrainClientRequest, opts ...grpc.CallOption) (*DrainClientResponse, error) {
+	out := new(DrainClientResponse)
+	err := c.cc.Invoke(ctx, "/api.ControlService/DrainClient", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ControlServiceServer is the server API for ControlService service.
 type ControlServiceServer interface {
 	CreateClient(*CreateClientRequest, ControlService_CreateClientServer) error
@@ -801,6 +1580,20 @@ type ControlServiceServer interface {
 	ListBackendServiceUsers(context.Context, *ListBackendServiceUsersRequest) (*ListBackendServiceUsersResponse, error)
 	StartInternalService(context.Context, *StartInternalServiceRequest) (*InternalService, error)
 	ListInternalService(context.Context, *ListInternalServiceRequest) (*ListInternalServiceResponse, error)
+	// Tunnel carries a multiplexed session for a client created with multiplex,
+	// client name is passed by l4proxy-client metadata.
+	Tunnel(ControlService_TunnelServer) error
+	// UpdateAccessList replaces access list of a running client.
+	UpdateAccessList(context.Context, *UpdateAccessListRequest) (*AccessList, error)
+	// ReportHealth reports health of the backend of a client, users are not
+	// handed to clients with unhealthy backend.
+	ReportHealth(context.Context, *ReportHealthRequest) (*Health, error)
+	// ReportDialFailure reports that the backend service user of token can not be
+	// served, the user is retried on another client sharing the public port or closed.
+	ReportDialFailure(context.Context, *ReportDialFailureRequest) (*ReportDialFailureResponse, error)
+	// DrainClient stops accepting backend service users of a client, existing users
+	// are served until they are gone or drain timeout, then the client is closed.
+	DrainClient(context.Context, *DrainClientRequest) (*DrainClientResponse, error)
 }
 
 // UnimplementedControlServiceServer can be embedded to have forward compatible implementations.
@@ -822,6 +1615,21 @@ func (*UnimplementedControlServiceServer) StartInternalService(ctx context.Conte
 func (*UnimplementedControlServiceServer) ListInternalService(ctx context.Context, req *ListInternalServiceRequest) (*ListInternalServiceResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method ListInternalService not implemented")
 }
+func (*UnimplementedControlServiceServer) Tunnel(srv ControlService_TunnelServer) error {
+	return status.Errorf(codes.Unimplemented, "method Tunnel not implemented")
+}
+func (*UnimplementedControlServiceServer) UpdateAccessList(ctx context.Context, req *UpdateAccessListRequest) (*AccessList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method UpdateAccessList not implemented")
+}
+func (*UnimplementedControlServiceServer) ReportHealth(ctx context.Context, req *ReportHealthRequest) (*Health, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReportHealth not implemented")
+}
+func (*UnimplementedControlServiceServer) ReportDialFailure(ctx context.Context, req *ReportDialFailureRequest) (*ReportDialFailureResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReportDialFailure not implemented")
+}
+func (*UnimplementedControlServiceServer) DrainClient(ctx context.Context, req *DrainClientRequest) (*DrainClientResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DrainClient not implemented")
+}
 
 func RegisterControlServiceServer(s *grpc.Server, srv ControlServiceServer) {
 	s.RegisterService(&_ControlService_serviceDesc, srv)
@@ -920,6 +1728,104 @@ func _ControlService_ListInternalService_Handler(srv interface{}, ctx context.Co
 	return interceptor(ctx, in, info, handler)
 }
 
+func _ControlService_Tunnel_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ControlServiceServer).Tunnel(&controlServiceTunnelServer{stream})
+}
+
+type ControlService_TunnelServer interface {
+	Send(*TunnelData) error
+	Recv() (*TunnelData, error)
+	grpc.ServerStream
+}
+
+type controlServiceTunnelServer struct {
+	grpc.ServerStream
+}
+
+func (x *controlServiceTunnelServer) Send(m *TunnelData) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *controlServiceTunnelServer) Recv() (*TunnelData, error) {
+	m := new(TunnelData)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _ControlService_UpdateAccessList_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UpdateAccessListRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServiceServer).UpdateAccessList(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.ControlService/UpdateAccessList",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServiceServer).UpdateAccessList(ctx, req.(*UpdateAccessListRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ControlService_ReportHealth_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReportHealthRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServiceServer).ReportHealth(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.ControlService/ReportHealth",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServiceServer).ReportHealth(ctx, req.(*ReportHealthRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ControlService_ReportDialFailure_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ReportDialFailureRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServiceServer).ReportDialFailure(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.ControlService/ReportDialFailure",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServiceServer).ReportDialFailure(ctx, req.(*ReportDialFailureRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _ControlService_DrainClient_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DrainClientRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ControlServiceServer).DrainClient(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/api.ControlService/DrainClient",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ControlServiceServer).DrainClient(ctx, req.(*DrainClientRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _ControlService_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "api.ControlService",
 	HandlerType: (*ControlServiceServer)(nil),
@@ -940,6 +1846,22 @@ var _ControlService_serviceDesc = grpc.ServiceDesc{
 			MethodName: "ListInternalService",
 			Handler:    _ControlService_ListInternalService_Handler,
 		},
+		{
+			MethodName: "UpdateAccessList",
+			Handler:    _ControlService_UpdateAccessList_Handler,
+		},
+		{
+			MethodName: "ReportHealth",
+			Handler:    _ControlService_ReportHealth_Handler,
+		},
+		{
+			MethodName: "ReportDialFailure",
+			Handler:    _ControlService_ReportDialFailure_Handler,
+		},
+		{
+			MethodName: "DrainClient",
+			Handler:    _ControlService_DrainClient_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -947,6 +1869,12 @@ var _ControlService_serviceDesc = grpc.ServiceDesc{
 			Handler:       _ControlService_CreateClient_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "Tunnel",
+			Handler:       _ControlService_Tunnel_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
 	Metadata: "proto/proxy.proto",
 }
@@ -20,6 +20,25 @@ func (this *CreateClientRequest) Validate() error {
 	if !(len([]rune(this.DisplayName)) < 20) {
 		return github_com_mwitkow_go_proto_validators.FieldError("DisplayName", fmt.Errorf(`max length of display name is 20`))
 	}
+	if this.AccessList != nil {
+		if err := github_com_mwitkow_go_proto_validators.CallValidatorIfExists(this.AccessList); err != nil {
+			return github_com_mwitkow_go_proto_validators.FieldError("AccessList", err)
+		}
+	}
+	return nil
+}
+func (this *AccessList) Validate() error {
+	return nil
+}
+func (this *UpdateAccessListRequest) Validate() error {
+	if this.Name == "" {
+		return github_com_mwitkow_go_proto_validators.FieldError("Name", fmt.Errorf(`value '%v' must not be an empty string`, this.Name))
+	}
+	if this.AccessList != nil {
+		if err := github_com_mwitkow_go_proto_validators.CallValidatorIfExists(this.AccessList); err != nil {
+			return github_com_mwitkow_go_proto_validators.FieldError("AccessList", err)
+		}
+	}
 	return nil
 }
 func (this *ListClientsRequest) Validate() error {
@@ -49,6 +68,52 @@ func (this *ListBackendServiceUsersResponse) Validate() error {
 	return nil
 }
 func (this *Client) Validate() error {
+	if this.Health != nil {
+		if err := github_com_mwitkow_go_proto_validators.CallValidatorIfExists(this.Health); err != nil {
+			return github_com_mwitkow_go_proto_validators.FieldError("Health", err)
+		}
+	}
+	return nil
+}
+func (this *Health) Validate() error {
+	return nil
+}
+func (this *ReportDialFailureRequest) Validate() error {
+	if this.Name == "" {
+		return github_com_mwitkow_go_proto_validators.FieldError("Name", fmt.Errorf(`value '%v' must not be an empty string`, this.Name))
+	}
+	if this.Token == "" {
+		return github_com_mwitkow_go_proto_validators.FieldError("Token", fmt.Errorf(`value '%v' must not be an empty string`, this.Token))
+	}
+	if this.Secret == "" {
+		return github_com_mwitkow_go_proto_validators.FieldError("Secret", fmt.Errorf(`value '%v' must not be an empty string`, this.Secret))
+	}
+	return nil
+}
+func (this *ReportDialFailureResponse) Validate() error {
+	return nil
+}
+func (this *DrainClientRequest) Validate() error {
+	if this.Name == "" {
+		return github_com_mwitkow_go_proto_validators.FieldError("Name", fmt.Errorf(`value '%v' must not be an empty string`, this.Name))
+	}
+	return nil
+}
+func (this *DrainClientResponse) Validate() error {
+	return nil
+}
+func (this *ReportHealthRequest) Validate() error {
+	if this.Name == "" {
+		return github_com_mwitkow_go_proto_validators.FieldError("Name", fmt.Errorf(`value '%v' must not be an empty string`, this.Name))
+	}
+	if nil == this.Health {
+		return github_com_mwitkow_go_proto_validators.FieldError("Health", fmt.Errorf("message must exist"))
+	}
+	if this.Health != nil {
+		if err := github_com_mwitkow_go_proto_validators.CallValidatorIfExists(this.Health); err != nil {
+			return github_com_mwitkow_go_proto_validators.FieldError("Health", err)
+		}
+	}
 	return nil
 }
 func (this *BackendServiceUser) Validate() error {
@@ -70,6 +135,9 @@ func (this *ListInternalServiceResponse) Validate() error {
 	}
 	return nil
 }
+func (this *TunnelData) Validate() error {
+	return nil
+}
 func (this *InternalService) Validate() error {
 	return nil
 }
@@ -0,0 +1,228 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"path"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	grpc_auth "github.com/grpc-ecosystem/go-grpc-middleware/auth"
+	"github.com/spf13/viper"
+	"github.com/winglq/l4proxy/src/api"
+	"github.com/winglq/l4proxy/src/config"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// Key is an api key and what it is allowed to do.
+type Key struct {
+	Key  string `mapstructure:"key"`
+	Name string `mapstructure:"name"`
+	// DisplayNames are patterns(path.Match) of display names which can be claimed, any name if empty.
+	DisplayNames []string `mapstructure:"display_names"`
+	// PublicPorts are ports or port ranges(e.g. 20000-20100) which can be claimed, any port if empty.
+	PublicPorts []string `mapstructure:"public_ports"`
+	// Admin keys are allowed to list clients and manage internal services.
+	Admin bool `mapstructure:"admin"`
+}
+
+// Policy maps api keys to permissions.
+type Policy struct {
+	Keys []*Key `mapstructure:"keys"`
+}
+
+// LoadPolicy loads policy from a yaml, json or toml file.
+func LoadPolicy(file string) (*Policy, error) {
+	v := viper.New()
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	p := &Policy{}
+	if err := v.Unmarshal(p); err != nil {
+		return nil, err
+	}
+	for _, k := range p.Keys {
+		if k.Key == "" {
+			return nil, fmt.Errorf("empty key found in %s", file)
+		}
+		for _, r := range k.PublicPorts {
+			if _, _, err := config.ParsePortRange(r); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return p, nil
+}
+
+// AddToken adds a pre-shared token which is allowed to do anything.
+func (p *Policy) AddToken(token string) {
+	p.Keys = append(p.Keys, &Key{
+		Key:   token,
+		Name:  "token",
+		Admin: true,
+	})
+}
+
+func (p *Policy) lookup(key string) *Key {
+	var found *Key
+	for _, k := range p.Keys {
+		if subtle.ConstantTimeCompare([]byte(k.Key), []byte(key)) == 1 {
+			found = k
+		}
+	}
+	return found
+}
+
+func (k *Key) AllowDisplayName(name string) bool {
+	if len(k.DisplayNames) == 0 {
+		return true
+	}
+	for _, pattern := range k.DisplayNames {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowPublicPort checks whether port can be claimed, random port(0)
+// is only allowed if there is no port restriction.
+func (k *Key) AllowPublicPort(port int32) bool {
+	if len(k.PublicPorts) == 0 {
+		return true
+	}
+	for _, r := range k.PublicPorts {
+		min, max, err := config.ParsePortRange(r)
+		if err != nil {
+			continue
+		}
+		if port >= min && port <= max {
+			return true
+		}
+	}
+	return false
+}
+
+func (k *Key) authorizeCreateClient(req *api.CreateClientRequest) error {
+	if !k.AllowDisplayName(req.DisplayName) {
+		return status.Errorf(codes.PermissionDenied, "display name %s is not allowed", req.DisplayName)
+	}
+	if !k.AllowPublicPort(req.PublicPort) {
+		return status.Errorf(codes.PermissionDenied, "public port %d is not allowed", req.PublicPort)
+	}
+	return nil
+}
+
+// methods which can be called by non admin keys.
+var clientMethods = map[string]bool{
+	"CreateClient": true,
+	"Tunnel":       true,
+	// owner of the client is checked by handler.
+	"UpdateAccessList": true,
+	"ReportHealth":     true,
+	"DrainClient":      true,
+	// secret of the user is checked by handler.
+	"ReportDialFailure": true,
+}
+
+func (k *Key) authorizeMethod(fullMethod string) error {
+	if k.Admin || clientMethods[path.Base(fullMethod)] {
+		return nil
+	}
+	return status.Errorf(codes.PermissionDenied, "%s is not allowed", path.Base(fullMethod))
+}
+
+type keyCtxMarker struct{}
+
+// FromContext returns the key used by the caller.
+func FromContext(ctx context.Context) (*Key, bool) {
+	k, ok := ctx.Value(keyCtxMarker{}).(*Key)
+	return k, ok
+}
+
+func (p *Policy) authenticate(ctx context.Context) (*Key, error) {
+	token, err := grpc_auth.AuthFromMD(ctx, "bearer")
+	if err != nil {
+		return nil, err
+	}
+	k := p.lookup(token)
+	if k == nil {
+		return nil, status.Errorf(codes.Unauthenticated, "invalid api key")
+	}
+	return k, nil
+}
+
+// UnaryServerInterceptor returns a new unary server interceptor that
+// authenticates api key and checks whether the method is allowed.
+func UnaryServerInterceptor(p *Policy) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		k, err := p.authenticate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := k.authorizeMethod(info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(context.WithValue(ctx, keyCtxMarker{}, k), req)
+	}
+}
+
+// StreamServerInterceptor returns a new stream server interceptor that
+// authenticates api key, checks whether the method is allowed and
+// whether the display name and public port in CreateClientRequest can be claimed.
+func StreamServerInterceptor(p *Policy) grpc.StreamServerInterceptor {
+	return func(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		k, err := p.authenticate(stream.Context())
+		if err != nil {
+			return err
+		}
+		if err := k.authorizeMethod(info.FullMethod); err != nil {
+			return err
+		}
+		wrapped := grpc_middleware.WrapServerStream(stream)
+		wrapped.WrappedContext = context.WithValue(stream.Context(), keyCtxMarker{}, k)
+		return handler(srv, &recvWrapper{wrapped, k})
+	}
+}
+
+type recvWrapper struct {
+	*grpc_middleware.WrappedServerStream
+	key *Key
+}
+
+func (s *recvWrapper) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	if req, ok := m.(*api.CreateClientRequest); ok {
+		return s.key.authorizeCreateClient(req)
+	}
+	return nil
+}
+
+type tokenCredentials struct {
+	token  string
+	secure bool
+}
+
+// NewTokenCredentials returns per rpc credentials which send token to server.
+func NewTokenCredentials(token string, secure bool) credentials.PerRPCCredentials {
+	return &tokenCredentials{
+		token:  token,
+		secure: secure,
+	}
+}
+
+func (t *tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{
+		"authorization": "Bearer " + t.token,
+	}, nil
+}
+
+func (t *tokenCredentials) RequireTransportSecurity() bool {
+	return t.secure
+}
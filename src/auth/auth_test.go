@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"context"
+	"testing"
+
+	"github.com/winglq/l4proxy/src/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func testPolicy() *Policy {
+	p := &Policy{Keys: []*Key{
+		{Key: "ssh-key", Name: "ssh", DisplayNames: []string{"ssh", "ssh-*"}, PublicPorts: []string{"2222", "20000-20100"}},
+		{Key: "any-key", Name: "any"},
+		{Key: "admin-key", Name: "admin", Admin: true},
+	}}
+	p.AddToken("token")
+	return p
+}
+
+func authContext(token string) context.Context {
+	if token == "" {
+		return context.Background()
+	}
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+}
+
+func codeOf(err error) codes.Code {
+	return status.Code(err)
+}
+
+func TestLookup(t *testing.T) {
+	p := testPolicy()
+	cases := []struct {
+		key  string
+		name string
+	}{
+		{"ssh-key", "ssh"},
+		{"admin-key", "admin"},
+		{"token", "token"},
+		{"ssh-ke", ""},
+		{"ssh-key2", ""},
+		{"", ""},
+	}
+	for _, c := range cases {
+		k := p.lookup(c.key)
+		name := ""
+		if k != nil {
+			name = k.Name
+		}
+		if name != c.name {
+			t.Errorf("lookup(%q) is %q, want %q", c.key, name, c.name)
+		}
+	}
+}
+
+func TestAllowDisplayName(t *testing.T) {
+	p := testPolicy()
+	cases := []struct {
+		key   string
+		name  string
+		allow bool
+	}{
+		{"ssh-key", "ssh", true},
+		{"ssh-key", "ssh-home", true},
+		{"ssh-key", "ssh-", true},
+		{"ssh-key", "sshd", false},
+		{"ssh-key", "nas", false},
+		{"ssh-key", "", false},
+		{"any-key", "nas", true},
+		{"any-key", "", true},
+	}
+	for _, c := range cases {
+		if allow := p.lookup(c.key).AllowDisplayName(c.name); allow != c.allow {
+			t.Errorf("%s allows display name %q: %v, want %v", c.key, c.name, allow, c.allow)
+		}
+	}
+}
+
+func TestAllowPublicPort(t *testing.T) {
+	p := testPolicy()
+	cases := []struct {
+		key   string
+		port  int32
+		allow bool
+	}{
+		{"ssh-key", 2222, true},
+		{"ssh-key", 20000, true},
+		{"ssh-key", 20050, true},
+		{"ssh-key", 20100, true},
+		{"ssh-key", 19999, false},
+		{"ssh-key", 20101, false},
+		{"ssh-key", 2223, false},
+		// random port is not allowed under a port restriction.
+		{"ssh-key", 0, false},
+		{"any-key", 0, true},
+		{"any-key", 80, true},
+	}
+	for _, c := range cases {
+		if allow := p.lookup(c.key).AllowPublicPort(c.port); allow != c.allow {
+			t.Errorf("%s allows public port %d: %v, want %v", c.key, c.port, allow, c.allow)
+		}
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	interceptor := UnaryServerInterceptor(testPolicy())
+	cases := []struct {
+		token  string
+		method string
+		code   codes.Code
+		name   string
+	}{
+		{"", "ListClients", codes.Unauthenticated, ""},
+		{"bad", "ListClients", codes.Unauthenticated, ""},
+		{"ssh-key", "ListClients", codes.PermissionDenied, ""},
+		{"ssh-key", "StartInternalService", codes.PermissionDenied, ""},
+		{"ssh-key", "DrainClient", codes.OK, "ssh"},
+		{"ssh-key", "UpdateAccessList", codes.OK, "ssh"},
+		{"ssh-key", "ReportDialFailure", codes.OK, "ssh"},
+		{"admin-key", "ListClients", codes.OK, "admin"},
+		{"token", "StartInternalService", codes.OK, "token"},
+	}
+	for _, c := range cases {
+		t.Run(c.token+" "+c.method, func(t *testing.T) {
+			var got *Key
+			_, err := interceptor(authContext(c.token), nil, &grpc.UnaryServerInfo{FullMethod: "/api.ControlService/" + c.method},
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					got, _ = FromContext(ctx)
+					return nil, nil
+				})
+			if code := codeOf(err); code != c.code {
+				t.Fatalf("code is %s, want %s: %v", code, c.code, err)
+			}
+			if c.code == codes.OK && (got == nil || got.Name != c.name) {
+				t.Fatalf("key in context is %v, want %s", got, c.name)
+			}
+			if c.code != codes.OK && got != nil {
+				t.Fatalf("handler is called with %s", got.Name)
+			}
+		})
+	}
+}
+
+// fakeStream returns req on RecvMsg.
+type fakeStream struct {
+	grpc.ServerStream
+	ctx context.Context
+	req *api.CreateClientRequest
+}
+
+func (s *fakeStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeStream) RecvMsg(m interface{}) error {
+	*m.(*api.CreateClientRequest) = *s.req
+	return nil
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	interceptor := StreamServerInterceptor(testPolicy())
+	cases := []struct {
+		name   string
+		token  string
+		method string
+		req    *api.CreateClientRequest
+		code   codes.Code
+		// called is whether the handler is called before the request is rejected.
+		called bool
+	}{
+		{"no key", "", "CreateClient", &api.CreateClientRequest{}, codes.Unauthenticated, false},
+		{"bad key", "bad", "CreateClient", &api.CreateClientRequest{}, codes.Unauthenticated, false},
+		{"denied method", "ssh-key", "ListClients", &api.CreateClientRequest{}, codes.PermissionDenied, false},
+		{"allowed", "ssh-key", "CreateClient", &api.CreateClientRequest{DisplayName: "ssh-home", PublicPort: 20022}, codes.OK, true},
+		{"denied name", "ssh-key", "CreateClient", &api.CreateClientRequest{DisplayName: "nas", PublicPort: 20022}, codes.PermissionDenied, true},
+		{"denied port", "ssh-key", "CreateClient", &api.CreateClientRequest{DisplayName: "ssh", PublicPort: 22}, codes.PermissionDenied, true},
+		{"denied random port", "ssh-key", "CreateClient", &api.CreateClientRequest{DisplayName: "ssh"}, codes.PermissionDenied, true},
+		{"unrestricted key", "any-key", "CreateClient", &api.CreateClientRequest{DisplayName: "nas"}, codes.OK, true},
+		{"admin", "admin-key", "CreateClient", &api.CreateClientRequest{DisplayName: "nas", PublicPort: 22}, codes.OK, true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			stream := &fakeStream{ctx: authContext(c.token), req: c.req}
+			called := false
+			err := interceptor(nil, stream, &grpc.StreamServerInfo{FullMethod: "/api.ControlService/" + c.method},
+				func(srv interface{}, stream grpc.ServerStream) error {
+					called = true
+					if _, ok := FromContext(stream.Context()); !ok {
+						t.Fatal("no key in context")
+					}
+					return stream.RecvMsg(&api.CreateClientRequest{})
+				})
+			if code := codeOf(err); code != c.code {
+				t.Fatalf("code is %s, want %s: %v", code, c.code, err)
+			}
+			if called != c.called {
+				t.Fatalf("handler is called: %v, want %v", called, c.called)
+			}
+		})
+	}
+}
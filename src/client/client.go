@@ -2,39 +2,247 @@ package client
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net"
 	"strconv"
+	"sync"
 	"time"
 
+	"github.com/hashicorp/yamux"
+	"github.com/inhies/go-bytesize"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"github.com/winglq/l4proxy/src/api"
+	"github.com/winglq/l4proxy/src/auth"
 	"github.com/winglq/l4proxy/src/handler"
 	"github.com/winglq/l4proxy/src/port_map"
+	"github.com/winglq/l4proxy/src/proxyproto"
+	"github.com/winglq/l4proxy/src/tlsutil"
+	"github.com/winglq/l4proxy/src/tunnel"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
 )
 
+// Options of a backend service, mapstructure tags are the keys used in config file.
 type Options struct {
-	SvrAddr     string
-	PubPort     int32
-	IntPort     int32
-	Name        string
-	SharePub    bool
-	BackendPort int32
+	SvrAddr     string `mapstructure:"svr_addr"`
+	PubPort     int32  `mapstructure:"pub_port"`
+	IntPort     int32  `mapstructure:"int_port"`
+	Name        string `mapstructure:"name"`
+	SharePub    bool   `mapstructure:"share_public_port"`
+	BackendPort int32  `mapstructure:"backend_port"`
+	TLS         bool   `mapstructure:"tls"`
+	CA          string `mapstructure:"ca"`
+	TLSCert     string `mapstructure:"tls_cert"`
+	TLSKey      string `mapstructure:"tls_key"`
+	AuthToken   string `mapstructure:"auth_token"`
+	Multiplex   bool   `mapstructure:"multiplex"`
+	Protocol    string `mapstructure:"protocol"`
+	// rate limits per second like 1MB, in is from backend service users to backend.
+	RateLimitIn      string `mapstructure:"rate_limit_in"`
+	RateLimitOut     string `mapstructure:"rate_limit_out"`
+	UserRateLimitIn  string `mapstructure:"user_rate_limit_in"`
+	UserRateLimitOut string `mapstructure:"user_rate_limit_out"`
+	MaxUsers         int32  `mapstructure:"max_users"`
+	// ProxyProtocol is the PROXY protocol version(v1 or v2) sent to backend, disabled if empty.
+	ProxyProtocol string `mapstructure:"proxy_protocol"`
+	// LBPolicy and Weight balance users of shared public port.
+	LBPolicy string `mapstructure:"lb_policy"`
+	Weight   int32  `mapstructure:"weight"`
+	// IdleTimeout, MaxSessionDuration and KeepAlive of users, server defaults are used if 0.
+	IdleTimeout        time.Duration `mapstructure:"idle_timeout"`
+	MaxSessionDuration time.Duration `mapstructure:"max_session_duration"`
+	KeepAlive          time.Duration `mapstructure:"keepalive_interval"`
+	// HealthCheck of backend is tcp or http, disabled if empty.
+	HealthCheck         string        `mapstructure:"health_check"`
+	HealthCheckPath     string        `mapstructure:"health_check_path"`
+	HealthCheckInterval time.Duration `mapstructure:"health_check_interval"`
+	// Allow and Deny are cidrs or ips of service users.
+	Allow []string `mapstructure:"allow"`
+	Deny  []string `mapstructure:"deny"`
+	// Host and Port of the backend service, only used by services in config file.
+	Host string `mapstructure:"host"`
+	Port string `mapstructure:"port"`
 }
 
-func createClient(client api.ControlServiceClient, opt *Options, backendPort int32) (api.ControlService_CreateClientClient, error) {
+// OnNewConnFunc is called for each new backend service user of the service at host:port.
+type OnNewConnFunc func(opt *Options, resp *api.Client, host, port string) (*handler.PairedConn, error)
+
+// AddSecurityFlags adds flags used to secure the connection to server.
+func AddSecurityFlags(fs *pflag.FlagSet, opt *Options) {
+	fs.StringVar(&opt.AuthToken, "auth_token", "", "api key or pre-shared token used to authenticate to server.")
+	fs.BoolVar(&opt.TLS, "tls", false, "use tls to connect server, implied by --ca, --tls_cert and --tls_key.")
+	fs.StringVar(&opt.CA, "ca", "", "ca certificate used to verify server, system roots are used if empty.")
+	fs.StringVar(&opt.TLSCert, "tls_cert", "", "client certificate presented to server.")
+	fs.StringVar(&opt.TLSKey, "tls_key", "", "client certificate key.")
+}
+
+func (opt *Options) tlsEnabled() bool {
+	return opt.TLS || opt.CA != "" || opt.TLSCert != "" || opt.TLSKey != ""
+}
+
+func (opt *Options) rateLimit() (handler.RateLimit, error) {
+	rl := handler.RateLimit{}
+	for _, l := range []struct {
+		s string
+		v *uint64
+	}{
+		{opt.RateLimitIn, &rl.In},
+		{opt.RateLimitOut, &rl.Out},
+		{opt.UserRateLimitIn, &rl.UserIn},
+		{opt.UserRateLimitOut, &rl.UserOut},
+	} {
+		if l.s == "" {
+			continue
+		}
+		b, err := bytesize.Parse(l.s)
+		if err != nil {
+			return rl, fmt.Errorf("invalid rate limit %s: %v", l.s, err)
+		}
+		*l.v = uint64(b)
+	}
+	return rl, nil
+}
+
+// sessions stores multiplexed sessions by client name.
+var sessions = sync.Map{}
+
+func openTunnel(client api.ControlServiceClient, name string) (*yamux.Session, error) {
+	ctx, cancel := context.WithCancel(metadata.AppendToOutgoingContext(context.Background(), tunnel.ClientMetadataKey, name))
+	s, err := client.Tunnel(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	session, err := tunnel.Client(s, cancel)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	sessions.Store(name, session)
+	go func() {
+		<-session.CloseChan()
+		sessions.Delete(name)
+	}()
+	return session, nil
+}
+
+// DialInternal connects to the internal address of server for a new backend service user,
+// or opens a stream in the tunnel if the client is multiplexed.
+func DialInternal(opt *Options, resp *api.Client) (net.Conn, error) {
+	if resp.InternalAddress == "" {
+		session, ok := sessions.Load(resp.Name)
+		if !ok {
+			return nil, fmt.Errorf("tunnel of %s is not open", resp.Name)
+		}
+		c, err := session.(*yamux.Session).Open()
+		if err != nil {
+			return nil, err
+		}
+		if err := handler.Handshake(c, resp); err != nil {
+			c.Close()
+			return nil, err
+		}
+		return c, nil
+	}
+	var cfg *tls.Config
+	if resp.InternalTls {
+		var err error
+		cfg, err = tlsutil.ClientConfig(opt.TLSCert, opt.TLSKey, opt.CA)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return handler.DialInternal(resp, cfg)
+}
+
+// WriteProxyHeader sends PROXY protocol header with address of the backend service user in resp
+// to backend if enabled by opt.
+func WriteProxyHeader(opt *Options, resp *api.Client, backend net.Conn) error {
+	if opt.ProxyProtocol == "" {
+		return nil
+	}
+	h := &proxyproto.Header{}
+	src, err := proxyproto.ParseAddr(resp.UserAddress)
+	if err == nil {
+		h.Src = src
+		h.Dst, err = proxyproto.ParseAddr(resp.PublicAddress)
+		if err != nil {
+			// public host is a hostname, keep the port only.
+			_, port, _ := net.SplitHostPort(resp.PublicAddress)
+			p, _ := strconv.Atoi(port)
+			h.Dst = &net.TCPAddr{IP: net.IPv4zero, Port: p}
+			if src.IP.To4() == nil {
+				h.Dst.IP = net.IPv6unspecified
+			}
+		}
+	}
+	return h.Write(backend, opt.ProxyProtocol)
+}
+
+// reportDialFailure tells server the backend service user in resp can not be served,
+// so that the user is retried on another client or closed.
+func reportDialFailure(client api.ControlServiceClient, resp *api.Client, dialErr error) {
+	r, err := client.ReportDialFailure(context.TODO(), &api.ReportDialFailureRequest{
+		Name:   resp.Name,
+		Token:  resp.Token,
+		Secret: resp.Secret,
+		Error:  dialErr.Error(),
+	})
+	if err != nil {
+		logrus.Errorf("report dial failure of %s failed: %v", resp.Token, err)
+		return
+	}
+	logrus.Debugf("dial failure of %s reported, retried: %t", resp.Token, r.Retried)
+}
+
+// Dial creates grpc connection to the server specified by opt.
+func Dial(opt *Options) (*grpc.ClientConn, error) {
+	dialOpts := []grpc.DialOption{}
+	if opt.tlsEnabled() {
+		cfg, err := tlsutil.ClientConfig(opt.TLSCert, opt.TLSKey, opt.CA)
+		if err != nil {
+			return nil, err
+		}
+		dialOpts = append(dialOpts, grpc.WithTransportCredentials(credentials.NewTLS(cfg)))
+	} else {
+		dialOpts = append(dialOpts, grpc.WithInsecure())
+	}
+	if opt.AuthToken != "" {
+		dialOpts = append(dialOpts, grpc.WithPerRPCCredentials(auth.NewTokenCredentials(opt.AuthToken, opt.tlsEnabled())))
+	}
+	return grpc.Dial(opt.SvrAddr, dialOpts...)
+}
+
+// createClient creates a client on server, or resumes the client of sessionID if not empty.
+func createClient(client api.ControlServiceClient, opt *Options, backendPort int32, rl handler.RateLimit, sessionID string) (api.ControlService_CreateClientClient, error) {
 	for {
 		c, err := client.CreateClient(context.TODO(), &api.CreateClientRequest{
-			DisplayName:     opt.Name,
-			PublicPort:      opt.PubPort,
-			InternalPort:    opt.IntPort,
-			SharePublicAddr: opt.SharePub,
-			Protocol:        "tcp",
-			BackendPort:     backendPort,
+			DisplayName:      opt.Name,
+			PublicPort:       opt.PubPort,
+			InternalPort:     opt.IntPort,
+			SharePublicAddr:  opt.SharePub,
+			Multiplex:        opt.Multiplex,
+			Protocol:         opt.Protocol,
+			BackendPort:      backendPort,
+			RateLimitIn:      rl.In,
+			RateLimitOut:     rl.Out,
+			UserRateLimitIn:  rl.UserIn,
+			UserRateLimitOut: rl.UserOut,
+			MaxUsers:         opt.MaxUsers,
+			LbPolicy:         opt.LBPolicy,
+			Weight:           opt.Weight,
+			AccessList:       &api.AccessList{Allow: opt.Allow, Deny: opt.Deny},
+
+			IdleTimeout:        int32(opt.IdleTimeout / time.Second),
+			MaxSessionDuration: int32(opt.MaxSessionDuration / time.Second),
+			KeepaliveInterval:  int32(opt.KeepAlive / time.Second),
+			SessionId:          sessionID,
 		})
 		if err == nil {
 			return c, nil
@@ -52,7 +260,7 @@ func createClient(client api.ControlServiceClient, opt *Options, backendPort int
 
 }
 
-func CreateRunFunc(done chan struct{}, opt *Options, onNewConn func(resp *api.Client, host, port string) (*handler.PairedConn, error)) func(cmd *cobra.Command, args []string) {
+func CreateRunFunc(done chan struct{}, opt *Options, onNewConn OnNewConnFunc) func(cmd *cobra.Command, args []string) {
 	ret := func(cmd *cobra.Command, args []string) {
 		port := "22"
 		host := "127.0.0.1"
@@ -62,66 +270,130 @@ func CreateRunFunc(done chan struct{}, opt *Options, onNewConn func(resp *api.Cl
 		if len(args) > 1 {
 			port = args[1]
 		}
-		c, err := grpc.Dial(opt.SvrAddr, grpc.WithInsecure())
+		Run(done, opt, host, port, onNewConn)
+	}
+	return ret
+}
+
+// Run exports the backend service at host:port until done is closed.
+func Run(done chan struct{}, opt *Options, host, port string, onNewConn OnNewConnFunc) {
+	rl, err := opt.rateLimit()
+	if err != nil {
+		logrus.Errorf("%v", err)
+		return
+	}
+	if opt.ProxyProtocol != "" && (opt.Protocol == "udp" || (opt.ProxyProtocol != proxyproto.V1 && opt.ProxyProtocol != proxyproto.V2)) {
+		logrus.Errorf("proxy protocol %s is not supported for %s", opt.ProxyProtocol, opt.Protocol)
+		return
+	}
+	if opt.HealthCheck != "" && ((opt.HealthCheck != "http" && opt.HealthCheck != "tcp") || opt.Protocol == "udp") {
+		logrus.Errorf("health check %s is not supported for %s", opt.HealthCheck, opt.Protocol)
+		return
+	}
+	c, err := Dial(opt)
+	if err != nil {
+		panic(err)
+	}
+	go func() {
+		<-done
+		c.Close()
+	}()
+	client := api.NewControlServiceClient(c)
+	backendPort := opt.BackendPort
+	var pt int64
+	if backendPort == 0 {
+		pt, err = strconv.ParseInt(port, 10, 32)
 		if err != nil {
-			panic(err)
+			log.Fatalf("backend port format error")
 		}
-		go func() {
-			<-done
-			c.Close()
-		}()
-		client := api.NewControlServiceClient(c)
-		backendPort := opt.BackendPort
-		var pt int64
-		if backendPort == 0 {
-			pt, err = strconv.ParseInt(port, 10, 32)
-			if err != nil {
-				log.Fatalf("backend port format error")
+		backendPort = int32(pt)
+	}
+	var session *yamux.Session
+	// healthDone stops health check of the current client name.
+	var healthDone chan struct{}
+	defer func() {
+		if session != nil {
+			session.Close()
+		}
+		if healthDone != nil {
+			close(healthDone)
+		}
+	}()
+	mapper := port_map.NewDummyPortMapper()
+	mapper.MapPort("", int32(pt), opt.Protocol, backendPort)
+	// sessionID resumes the client on server after the stream breaks.
+	var sessionID string
+	conClient, err := createClient(client, opt, backendPort, rl, sessionID)
+	if err != nil && grpc.Code(err) == codes.Canceled {
+		return
+	} else if err != nil {
+		panic(err)
+	}
+	// draining means server is shutting down or the client is drained by DrainClient.
+	draining := false
+	for {
+		resp, err := conClient.Recv()
+		if err != nil {
+			if grpc.Code(err) == codes.Canceled {
+				break
+			} else if code := grpc.Code(err); code == codes.Unauthenticated || code == codes.PermissionDenied ||
+				code == codes.InvalidArgument || code == codes.FailedPrecondition {
+				logrus.Errorf("rejected by server: %v", err)
+				break
+			} else {
+				logrus.Errorf("recv messsage failed: %v", err)
+				if draining {
+					// server refuses new clients until it exits.
+					draining = false
+					time.Sleep(5 * time.Second)
+				}
+				conClient, err = createClient(client, opt, backendPort, rl, sessionID)
+				if err != nil && grpc.Code(err) == codes.Canceled {
+					mapper.UnmapPort(opt.Protocol, backendPort)
+					return
+				}
+				continue
 			}
-			backendPort = int32(pt)
 		}
-		mapper := port_map.NewDummyPortMapper()
-		mapper.MapPort("", int32(pt), "tcp", backendPort)
-		conClient, err := createClient(client, opt, backendPort)
-		if err != nil && grpc.Code(err) == codes.Canceled {
-			return
-		} else if err != nil {
-			panic(err)
+		if resp.Draining {
+			logrus.Warnf("client %s is draining, existing backend service users are still served", resp.Name)
+			draining = true
+			continue
 		}
-		for {
-			resp, err := conClient.Recv()
+		if resp.Token != "" {
+			pair, err := onNewConn(opt, resp, host, port)
 			if err != nil {
-				if grpc.Code(err) == codes.Canceled {
-					break
-				} else {
-					logrus.Errorf("recv messsage failed: %v", err)
-					conClient, err = createClient(client, opt, backendPort)
-					if err != nil && grpc.Code(err) == codes.Canceled {
-						mapper.UnmapPort("tcp", backendPort)
-						return
-					}
-					continue
-				}
+				fmt.Printf("create pair failed: %v\n", err)
+				go reportDialFailure(client, resp, err)
 			}
-			if resp.InternalAddress != "" {
-				pair, err := onNewConn(resp, host, port)
-				if err != nil {
-					fmt.Printf("create pair failed: %v\n", err)
-				}
-				if pair != nil {
-					defer pair.Close()
+			if pair != nil {
+				defer pair.Close()
+			}
+		} else {
+			fmt.Printf("PUBLIC ADDRESS: %s\n", resp.PublicAddress)
+			sessionID = resp.SessionId
+			_, pubPort, err := net.SplitHostPort(resp.PublicAddress)
+			if err != nil {
+				panic(err)
+			}
+			p, _ := strconv.ParseInt(pubPort, 10, 64)
+			opt.PubPort = int32(p)
+			if opt.Multiplex && resp.Name != "" {
+				if session != nil {
+					session.Close()
 				}
-			} else {
-				fmt.Printf("PUBLIC ADDRESS: %s\n", resp.PublicAddress)
-				_, port, err := net.SplitHostPort(resp.PublicAddress)
+				session, err = openTunnel(client, resp.Name)
 				if err != nil {
-					panic(err)
+					logrus.Errorf("open tunnel failed: %v", err)
+				}
+			}
+			if opt.HealthCheck != "" && resp.Name != "" {
+				if healthDone != nil {
+					close(healthDone)
 				}
-				p, _ := strconv.ParseInt(port, 10, 64)
-				opt.PubPort = int32(p)
+				healthDone = make(chan struct{})
+				go runHealthCheck(healthDone, client, resp.Name, opt, host, port)
 			}
 		}
-
 	}
-	return ret
 }
@@ -6,38 +6,127 @@ import (
 	"log"
 	"net"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/fsnotify/fsnotify"
 	"github.com/inhies/go-bytesize"
 	"github.com/olekukonko/tablewriter"
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 	"github.com/winglq/l4proxy/src/api"
 	"github.com/winglq/l4proxy/src/client"
 	"github.com/winglq/l4proxy/src/client/forwarder"
+	"github.com/winglq/l4proxy/src/config"
 	"github.com/winglq/l4proxy/src/handler"
-	"google.golang.org/grpc"
 )
 
 var opt client.Options
 var done = make(chan struct{})
+var configFile string
 
 func NewClientCmd() *cobra.Command {
 	cmd := cobra.Command{
 		Use:  "client [host] [port]",
 		Args: cobra.MaximumNArgs(2),
-		Run:  client.CreateRunFunc(done, &opt, dialToBackend),
+		Run: func(cmd *cobra.Command, args []string) {
+			if configFile == "" {
+				client.CreateRunFunc(done, &opt, dialToBackend)(cmd, args)
+				return
+			}
+			v, err := config.Load(configFile, cmd.Flags())
+			if err != nil {
+				log.Fatalf("failed to load config: %v", err)
+			}
+			services, err := client.LoadServices(v, &opt)
+			if err != nil {
+				log.Fatalf("failed to load services: %v", err)
+			}
+			runServices(v, services)
+		},
 	}
+	cmd.Flags().StringVar(&configFile, "config", "", "config file(yaml, json or toml) with a services list, each service is exported as a client.")
 	cmd.PersistentFlags().StringVar(&opt.SvrAddr, "svr_addr", "127.0.0.1:2222", "server address.")
+	client.AddSecurityFlags(cmd.PersistentFlags(), &opt)
 	cmd.Flags().Int32Var(&opt.PubPort, "pub_port", 0, "public port for this client.")
 	cmd.Flags().Int32Var(&opt.IntPort, "int_port", 0, "internal port used to listen client connection.")
 	cmd.Flags().StringVar(&opt.Name, "client_name", "unknown", "client name")
 	cmd.Flags().BoolVar(&opt.SharePub, "share_public_port", false, "share public port for different clients")
 	cmd.Flags().Int32Var(&opt.BackendPort, "backend_port", 0, "stun port used to be connected by service users")
+	cmd.Flags().StringVar(&opt.Protocol, "protocol", "tcp", "protocol of backend service, tcp or udp")
+	cmd.Flags().StringVar(&opt.RateLimitIn, "rate_limit_in", "", "max bytes per second from all service users to backend, e.g. 1MB")
+	cmd.Flags().StringVar(&opt.RateLimitOut, "rate_limit_out", "", "max bytes per second from backend to all service users, e.g. 1MB")
+	cmd.Flags().StringVar(&opt.UserRateLimitIn, "user_rate_limit_in", "", "max bytes per second from each service user to backend, e.g. 1MB")
+	cmd.Flags().StringVar(&opt.UserRateLimitOut, "user_rate_limit_out", "", "max bytes per second from backend to each service user, e.g. 1MB")
+	cmd.Flags().Int32Var(&opt.MaxUsers, "max_users", 0, "max concurrent service users, unlimited if 0")
+	cmd.Flags().StringVar(&opt.ProxyProtocol, "proxy_protocol", "", "send PROXY protocol header(v1 or v2) with address of service user to tcp backend, disabled if empty")
+	cmd.Flags().StringVar(&opt.LBPolicy, "lb_policy", "", "policy balancing users of shared public port: round_robin(default), least_conn, weighted or source_hash")
+	cmd.Flags().Int32Var(&opt.Weight, "weight", 1, "weight of this client for weighted lb policy")
+	cmd.Flags().DurationVar(&opt.IdleTimeout, "idle_timeout", 0, "service users without bytes in either direction in this duration are closed by server, server default if 0")
+	cmd.Flags().DurationVar(&opt.MaxSessionDuration, "max_session_duration", 0, "service users connected for this duration are closed by server, server default if 0")
+	cmd.Flags().DurationVar(&opt.KeepAlive, "keepalive_interval", 0, "tcp keepalive period of service users and data connections on server, server default if 0")
+	cmd.Flags().StringVar(&opt.HealthCheck, "health_check", "", "check tcp backend by tcp connect or http get and report to server, users are not handed to this client while backend is unhealthy, disabled if empty")
+	cmd.Flags().StringVar(&opt.HealthCheckPath, "health_check_path", "/", "path of http health check")
+	cmd.Flags().DurationVar(&opt.HealthCheckInterval, "health_check_interval", 10*time.Second, "interval of health check")
+	cmd.Flags().StringSliceVar(&opt.Allow, "allow", nil, "cidrs or ips of service users allowed, all are allowed if empty")
+	cmd.Flags().StringSliceVar(&opt.Deny, "deny", nil, "cidrs or ips of service users denied, take precedence over --allow")
+	cmd.Flags().BoolVar(&opt.Multiplex, "multiplex", false, "carry all data connections in one tunnel over the control connection, no internal port is required")
 	list := newListClientsCmd()
 	fwd := forwarder.NewForwarderBackendCmd(&opt)
-	cmd.AddCommand(list, fwd)
+	acl := newAccessListCmd()
+	drain := newDrainCmd()
+	cmd.AddCommand(list, fwd, acl, drain)
 	return &cmd
 }
 
+// runServices runs services in config file until done is closed,
+// services are reloaded when config file changes or SIGHUP is received.
+// Each reload reads the config file into a fresh viper, v is only
+// watched, so that reloads never race with the watcher re-reading v.
+func runServices(v *viper.Viper, services []*client.Options) {
+	s := client.NewServices(dialToBackend)
+	s.Update(services)
+	var mu sync.Mutex
+	reload := func() {
+		mu.Lock()
+		defer mu.Unlock()
+		nv := viper.New()
+		nv.SetConfigFile(configFile)
+		if err := nv.ReadInConfig(); err != nil {
+			logrus.Errorf("read config failed: %v", err)
+			return
+		}
+		services, err := client.LoadServices(nv, &opt)
+		if err != nil {
+			logrus.Errorf("reload services failed: %v", err)
+			return
+		}
+		s.Update(services)
+	}
+	v.OnConfigChange(func(e fsnotify.Event) {
+		logrus.Infof("config file changed, reloading services")
+		reload()
+	})
+	v.WatchConfig()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-hup:
+			logrus.Infof("SIGHUP received, reloading services")
+			reload()
+		case <-done:
+			s.Close()
+			return
+		}
+	}
+}
+
 func Close() {
 	close(done)
 }
@@ -51,7 +140,7 @@ func newListClientUsersCmd() *cobra.Command {
 				fmt.Println("client_name is reqired")
 				return
 			}
-			c, err := grpc.Dial(opt.SvrAddr, grpc.WithInsecure())
+			c, err := client.Dial(&opt)
 			if err != nil {
 				log.Fatalf("failed to dial to grpc server: %v", err)
 			}
@@ -63,9 +152,10 @@ func newListClientUsersCmd() *cobra.Command {
 				log.Fatalf("list clients users failed: %v", err)
 			}
 			table := tablewriter.NewWriter(os.Stdout)
-			table.SetHeader([]string{"User Address", "Speed In", "Speed Out"})
+			table.SetHeader([]string{"User Address", "Speed In", "Speed Out", "Bytes In", "Bytes Out"})
 			for _, u := range resp.Users {
-				table.Append([]string{u.UserAddr, bytesize.New(u.SpeedIn).String(), bytesize.New(u.SpeedOut).String()})
+				table.Append([]string{u.UserAddr, bytesize.New(u.SpeedIn).String(), bytesize.New(u.SpeedOut).String(),
+					bytesize.New(float64(u.BytesIn)).String(), bytesize.New(float64(u.BytesOut)).String()})
 
 			}
 			table.Render()
@@ -75,21 +165,85 @@ func newListClientUsersCmd() *cobra.Command {
 	return &cmd
 }
 
-func dialToBackend(resp *api.Client, host, port string) (*handler.PairedConn, error) {
-	c, err := net.Dial("tcp", resp.InternalAddress)
+func newAccessListCmd() *cobra.Command {
+	var allow, deny []string
+	cmd := cobra.Command{
+		Use:   "access-list <client name>",
+		Short: "replace access list of a running client",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			c, err := client.Dial(&opt)
+			if err != nil {
+				panic(err)
+			}
+			client := api.NewControlServiceClient(c)
+			resp, err := client.UpdateAccessList(context.TODO(), &api.UpdateAccessListRequest{
+				Name:       args[0],
+				AccessList: &api.AccessList{Allow: allow, Deny: deny},
+			})
+			if err != nil {
+				log.Fatalf("update access list failed: %v", err)
+			}
+			table := tablewriter.NewWriter(os.Stdout)
+			table.SetHeader([]string{"Allow", "Deny"})
+			table.Append([]string{strings.Join(resp.Allow, ","), strings.Join(resp.Deny, ",")})
+			table.Render()
+		},
+	}
+	cmd.Flags().StringSliceVar(&allow, "allow", nil, "cidrs or ips of service users allowed, all are allowed if empty")
+	cmd.Flags().StringSliceVar(&deny, "deny", nil, "cidrs or ips of service users denied, take precedence over --allow")
+	return &cmd
+}
+
+func newDrainCmd() *cobra.Command {
+	var timeout time.Duration
+	cmd := cobra.Command{
+		Use:   "drain <client name>",
+		Short: "stop accepting service users of a client and close it after existing users are gone",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			c, err := client.Dial(&opt)
+			if err != nil {
+				panic(err)
+			}
+			client := api.NewControlServiceClient(c)
+			resp, err := client.DrainClient(context.TODO(), &api.DrainClientRequest{
+				Name:         args[0],
+				DrainTimeout: int32(timeout / time.Second),
+			})
+			if err != nil {
+				log.Fatalf("drain client failed: %v", err)
+			}
+			fmt.Printf("%s is draining %d service users\n", args[0], resp.Users)
+		},
+	}
+	cmd.Flags().DurationVar(&timeout, "drain_timeout", 0, "time to wait for existing service users, server default if 0")
+	return &cmd
+}
+
+func dialToBackend(opt *client.Options, resp *api.Client, host, port string) (*handler.PairedConn, error) {
+	// backend is dialed first, so that the user is not taken if backend is down
+	// and server can hand it to another client.
+	protocol := "tcp"
+	if opt.Protocol == "udp" {
+		protocol = "udp"
+	}
+	sconn, err := net.Dial(protocol, net.JoinHostPort(host, port))
 	if err != nil {
 		return nil, err
 	}
-	_, err = c.Write([]byte(resp.Token))
-	if err != nil {
+	if err := client.WriteProxyHeader(opt, resp, sconn); err != nil {
+		sconn.Close()
 		return nil, err
 	}
-	sconn, err := net.Dial("tcp", fmt.Sprintf("%s:%s", host, port))
+	c, err := client.DialInternal(opt, resp)
 	if err != nil {
-		//TODO: return error code, and close c
-		c.Close()
+		sconn.Close()
 		return nil, err
 	}
+	if opt.Protocol == "udp" {
+		c = handler.NewFramedConn(c)
+	}
 	log.Printf("connected to backend service: %s -> %s", sconn.LocalAddr(), sconn.RemoteAddr())
 	pair := handler.NewPairedConn(c, sconn)
 	pair.Copy()
@@ -100,7 +254,7 @@ func newListClientsCmd() *cobra.Command {
 	cmd := cobra.Command{
 		Use: "list",
 		Run: func(cmd *cobra.Command, args []string) {
-			c, err := grpc.Dial(opt.SvrAddr, grpc.WithInsecure())
+			c, err := client.Dial(&opt)
 			if err != nil {
 				panic(err)
 			}
@@ -111,9 +265,11 @@ func newListClientsCmd() *cobra.Command {
 				os.Exit(1)
 			}
 			table := tablewriter.NewWriter(os.Stdout)
-			table.SetHeader([]string{"Name", "Display Name", "Public Address", "Internal Address"})
+			table.SetHeader([]string{"Name", "Display Name", "Public Address", "Internal Address", "Bytes In", "Bytes Out", "Rejected Users", "Unclaimed Users", "Healthy", "Draining"})
 			for _, cl := range resp.Clients {
-				table.Append([]string{cl.Name, cl.DisplayName, cl.PublicAddress, cl.InternalAddress})
+				table.Append([]string{cl.Name, cl.DisplayName, cl.PublicAddress, cl.InternalAddress,
+					bytesize.New(float64(cl.BytesIn)).String(), bytesize.New(float64(cl.BytesOut)).String(),
+					fmt.Sprintf("%d", cl.RejectedUsers), fmt.Sprintf("%d", cl.UnclaimedUsers), fmt.Sprintf("%t", cl.Health.GetHealthy()), fmt.Sprintf("%t", cl.Draining)})
 
 			}
 			table.Render()
@@ -0,0 +1,106 @@
+package client
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+// LoadServices reads the services list of config file, each service
+// starts from base so that server address and credentials can be shared.
+func LoadServices(v *viper.Viper, base *Options) ([]*Options, error) {
+	raw, ok := v.Get("services").([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("services list is required in config file")
+	}
+	opts := []*Options{}
+	for i, r := range raw {
+		opt := *base
+		opt.Host = "127.0.0.1"
+		opt.Port = "22"
+		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+			WeaklyTypedInput: true,
+			DecodeHook:       mapstructure.StringToTimeDurationHookFunc(),
+			Result:           &opt,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := decoder.Decode(r); err != nil {
+			return nil, fmt.Errorf("invalid service %d: %v", i, err)
+		}
+		if opt.Name == "" {
+			return nil, fmt.Errorf("name of service %d is required", i)
+		}
+		opts = append(opts, &opt)
+	}
+	return opts, nil
+}
+
+// Services keeps running services by their options, so that updating
+// only starts new services and stops removed ones, connections of the
+// services which are not changed are kept.
+type Services struct {
+	onNewConn OnNewConnFunc
+	running   map[string]*service
+	mu        sync.Mutex
+	wg        sync.WaitGroup
+}
+
+type service struct {
+	opt  Options
+	done chan struct{}
+}
+
+// key identifies options of a service, Options is not comparable.
+func (opt *Options) key() string {
+	return fmt.Sprintf("%+v", *opt)
+}
+
+func NewServices(onNewConn OnNewConnFunc) *Services {
+	return &Services{
+		onNewConn: onNewConn,
+		running:   map[string]*service{},
+	}
+}
+
+// Update starts services in opts which are not running and stops running
+// services which are not in opts, a changed service is restarted.
+func (s *Services) Update(opts []*Options) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wanted := map[string]*Options{}
+	for _, opt := range opts {
+		wanted[opt.key()] = opt
+	}
+	for k, svc := range s.running {
+		if _, ok := wanted[k]; !ok {
+			logrus.Infof("stop service %s(%s:%s)", svc.opt.Name, svc.opt.Host, svc.opt.Port)
+			close(svc.done)
+			delete(s.running, k)
+		}
+	}
+	for k, o := range wanted {
+		if _, ok := s.running[k]; ok {
+			continue
+		}
+		logrus.Infof("start service %s(%s:%s)", o.Name, o.Host, o.Port)
+		// Run updates the options, so it works on a copy.
+		svc := &service{opt: *o, done: make(chan struct{})}
+		s.running[k] = svc
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			Run(svc.done, &svc.opt, svc.opt.Host, svc.opt.Port, s.onNewConn)
+		}()
+	}
+}
+
+// Close stops all services and waits them to exit.
+func (s *Services) Close() {
+	s.Update(nil)
+	s.wg.Wait()
+}
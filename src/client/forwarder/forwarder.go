@@ -92,12 +92,8 @@ func (fl *forwarderListener) Addr() net.Addr {
 	return FakeAddr{}
 }
 
-func (fl *forwarderListener) httpForwarder(resp *api.Client, host, port string) (*handler.PairedConn, error) {
-	c, err := net.Dial("tcp", resp.InternalAddress)
-	if err != nil {
-		return nil, err
-	}
-	_, err = c.Write([]byte(resp.Token))
+func (fl *forwarderListener) httpForwarder(opt *client.Options, resp *api.Client, host, port string) (*handler.PairedConn, error) {
+	c, err := client.DialInternal(opt, resp)
 	if err != nil {
 		return nil, err
 	}
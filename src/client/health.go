@@ -0,0 +1,71 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/winglq/l4proxy/src/api"
+)
+
+const healthCheckTimeout = 3 * time.Second
+
+// checkHealth checks the backend at host:port by tcp connect or http get.
+func checkHealth(opt *Options, host, port string) *api.Health {
+	addr := net.JoinHostPort(host, port)
+	switch opt.HealthCheck {
+	case "tcp":
+		c, err := net.DialTimeout("tcp", addr, healthCheckTimeout)
+		if err != nil {
+			return &api.Health{Detail: err.Error()}
+		}
+		c.Close()
+	case "http":
+		cl := http.Client{Timeout: healthCheckTimeout}
+		resp, err := cl.Get(fmt.Sprintf("http://%s%s", addr, opt.HealthCheckPath))
+		if err != nil {
+			return &api.Health{Detail: err.Error()}
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return &api.Health{Detail: resp.Status}
+		}
+	}
+	return &api.Health{Healthy: true}
+}
+
+// runHealthCheck checks the backend every interval and reports changes to server
+// as client name until done is closed.
+func runHealthCheck(done <-chan struct{}, client api.ControlServiceClient, name string, opt *Options, host, port string) {
+	interval := opt.HealthCheckInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	var last *api.Health
+	for {
+		h := checkHealth(opt, host, port)
+		if last == nil || last.Healthy != h.Healthy {
+			if !h.Healthy {
+				logrus.Warnf("backend %s:%s is unhealthy: %s", host, port, h.Detail)
+			}
+			if _, err := client.ReportHealth(context.TODO(), &api.ReportHealthRequest{
+				Name:   name,
+				Health: h,
+			}); err != nil {
+				logrus.Errorf("report health failed: %v", err)
+			} else {
+				last = h
+			}
+		}
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+	}
+}
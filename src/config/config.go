@@ -0,0 +1,52 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// Load reads a yaml, json or toml config file, keys in the file are
+// named after flags. Values in the file are applied to the flags which
+// are not set in command line, so command line always wins.
+// The returned viper can be used to read keys which are not flags.
+func Load(file string, fs *pflag.FlagSet) (*viper.Viper, error) {
+	v := viper.New()
+	v.SetConfigFile(file)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	var err error
+	fs.VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed || !v.IsSet(f.Name) {
+			return
+		}
+		if e := fs.Set(f.Name, v.GetString(f.Name)); e != nil {
+			err = fmt.Errorf("invalid value of %s in %s: %v", f.Name, file, e)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// ParsePortRange parses a port(2222) or a port range(20000-20100).
+func ParsePortRange(r string) (int32, int32, error) {
+	parts := strings.SplitN(r, "-", 2)
+	min, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %s", r)
+	}
+	max := min
+	if len(parts) == 2 {
+		max, err = strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 32)
+		if err != nil || max < min {
+			return 0, 0, fmt.Errorf("invalid port range %s", r)
+		}
+	}
+	return int32(min), int32(max), nil
+}
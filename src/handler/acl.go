@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/spf13/cast"
+	"github.com/winglq/l4proxy/src/api"
+)
+
+// AccessList allows or denies backend service users by source ip.
+// Deny takes precedence, all ips are allowed if Allow is empty.
+type AccessList struct {
+	Allow []*net.IPNet
+	Deny  []*net.IPNet
+}
+
+func parseCIDRs(items []string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, item := range items {
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid ip %s", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// ParseAccessList parses allow and deny lists of cidrs or ips,
+// nil is returned if both are empty.
+func ParseAccessList(allow, deny []string) (*AccessList, error) {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, nil
+	}
+	var err error
+	l := &AccessList{}
+	if l.Allow, err = parseCIDRs(allow); err != nil {
+		return nil, err
+	}
+	if l.Deny, err = parseCIDRs(deny); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// ParseAccessLists parses access lists from config.
+//
+//	rdp:
+//	  allow: [10.0.0.0/8, 1.2.3.4]
+//	  deny: [10.0.1.0/24]
+func ParseAccessLists(raw map[string]interface{}) (map[string]*AccessList, error) {
+	ls := map[string]*AccessList{}
+	for name, v := range raw {
+		m, err := cast.ToStringMapE(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid access list of %s: %v", name, err)
+		}
+		lists := map[string][]string{}
+		for k, items := range m {
+			if k != "allow" && k != "deny" {
+				return nil, fmt.Errorf("unknown access list %s of %s", k, name)
+			}
+			if lists[k], err = cast.ToStringSliceE(items); err != nil {
+				return nil, fmt.Errorf("invalid access list of %s: %v", name, err)
+			}
+		}
+		l, err := ParseAccessList(lists["allow"], lists["deny"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid access list of %s: %v", name, err)
+		}
+		ls[name] = l
+	}
+	return ls, nil
+}
+
+func accessListOfRequest(l *api.AccessList) (*AccessList, error) {
+	if l == nil {
+		return nil, nil
+	}
+	return ParseAccessList(l.Allow, l.Deny)
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowed reports whether users from addr are allowed, nil list allows all.
+func (l *AccessList) allowed(addr net.Addr) bool {
+	if l == nil {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	if containsIP(l.Deny, ip) {
+		return false
+	}
+	return len(l.Allow) == 0 || containsIP(l.Allow, ip)
+}
+
+func (l *AccessList) toAPI() *api.AccessList {
+	ret := &api.AccessList{}
+	if l == nil {
+		return ret
+	}
+	for _, n := range l.Allow {
+		ret.Allow = append(ret.Allow, n.String())
+	}
+	for _, n := range l.Deny {
+		ret.Deny = append(ret.Deny, n.String())
+	}
+	return ret
+}
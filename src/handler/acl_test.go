@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/winglq/l4proxy/src/api"
+)
+
+func TestParseAccessList(t *testing.T) {
+	cases := []struct {
+		name    string
+		allow   []string
+		deny    []string
+		want    *api.AccessList
+		wantErr bool
+	}{
+		{name: "empty"},
+		{name: "ipv4", allow: []string{"1.2.3.4"}, want: &api.AccessList{Allow: []string{"1.2.3.4/32"}, Deny: []string{}}},
+		{name: "ipv6", deny: []string{"2001:db8::1"}, want: &api.AccessList{Allow: []string{}, Deny: []string{"2001:db8::1/128"}}},
+		{name: "ipv4 mapped ipv6", allow: []string{"::ffff:1.2.3.4"}, want: &api.AccessList{Allow: []string{"1.2.3.4/32"}, Deny: []string{}}},
+		{name: "cidrs", allow: []string{"10.0.0.0/8", "2001:db8::/32"}, deny: []string{"10.0.1.5/24"},
+			want: &api.AccessList{Allow: []string{"10.0.0.0/8", "2001:db8::/32"}, Deny: []string{"10.0.1.0/24"}}},
+		{name: "invalid ip", allow: []string{"1.2.3"}, wantErr: true},
+		{name: "hostname", deny: []string{"localhost"}, wantErr: true},
+		{name: "invalid cidr", allow: []string{"10.0.0.0/33"}, wantErr: true},
+		{name: "invalid deny after valid allow", allow: []string{"10.0.0.0/8"}, deny: []string{"10.0.0.0/"}, wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			l, err := ParseAccessList(c.allow, c.deny)
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("got %v, want error", l.toAPI())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.want == nil {
+				if l != nil {
+					t.Fatalf("got %v, want nil", l.toAPI())
+				}
+				return
+			}
+			got := l.toAPI()
+			if got.Allow == nil {
+				got.Allow = []string{}
+			}
+			if got.Deny == nil {
+				got.Deny = []string{}
+			}
+			if !reflect.DeepEqual(got.Allow, c.want.Allow) || !reflect.DeepEqual(got.Deny, c.want.Deny) {
+				t.Fatalf("got allow %v deny %v, want allow %v deny %v", got.Allow, got.Deny, c.want.Allow, c.want.Deny)
+			}
+		})
+	}
+}
+
+func TestAccessListAllowed(t *testing.T) {
+	mustParse := func(allow, deny []string) *AccessList {
+		l, err := ParseAccessList(allow, deny)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+	cases := []struct {
+		name  string
+		list  *AccessList
+		addr  string
+		allow bool
+	}{
+		{"nil list", nil, "1.2.3.4:80", true},
+		{"empty allow", mustParse(nil, []string{"10.0.0.0/8"}), "1.2.3.4:80", true},
+		{"empty allow denied", mustParse(nil, []string{"10.0.0.0/8"}), "10.1.2.3:80", false},
+		{"allowed ip", mustParse([]string{"1.2.3.4"}, nil), "1.2.3.4:80", true},
+		{"not allowed ip", mustParse([]string{"1.2.3.4"}, nil), "1.2.3.5:80", false},
+		{"allowed cidr", mustParse([]string{"10.0.0.0/8"}, nil), "10.255.0.1:80", true},
+		{"deny takes precedence", mustParse([]string{"10.0.0.0/8"}, []string{"10.0.1.0/24"}), "10.0.1.9:80", false},
+		{"allowed outside deny", mustParse([]string{"10.0.0.0/8"}, []string{"10.0.1.0/24"}), "10.0.2.9:80", true},
+		{"same ip allowed and denied", mustParse([]string{"1.2.3.4"}, []string{"1.2.3.4"}), "1.2.3.4:80", false},
+		{"ipv6 allowed", mustParse([]string{"2001:db8::/32"}, nil), "[2001:db8::5]:80", true},
+		{"ipv6 not allowed", mustParse([]string{"2001:db8::/32"}, nil), "[2001:db9::5]:80", false},
+		{"ipv6 ip denied", mustParse(nil, []string{"2001:db8::1"}), "[2001:db8::1]:80", false},
+		{"ipv6 next ip", mustParse(nil, []string{"2001:db8::1"}), "[2001:db8::2]:80", true},
+		{"ipv4 mapped user", mustParse([]string{"1.2.3.4"}, nil), "[::ffff:1.2.3.4]:80", true},
+		{"ipv4 list ipv6 user", mustParse([]string{"1.2.3.4"}, nil), "[2001:db8::1]:80", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			addr, err := net.ResolveTCPAddr("tcp", c.addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if allow := c.list.allowed(addr); allow != c.allow {
+				t.Fatalf("%s is allowed: %v, want %v", c.addr, allow, c.allow)
+			}
+		})
+	}
+}
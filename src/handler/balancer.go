@@ -0,0 +1,247 @@
+package handler
+
+import (
+	"hash/fnv"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// policies balancing users of a shared public port.
+const (
+	PolicyRoundRobin = "round_robin"
+	PolicyLeastConn  = "least_conn"
+	PolicyWeighted   = "weighted"
+	PolicySourceHash = "source_hash"
+)
+
+// ValidPolicy reports whether p is a known policy, empty means round robin.
+func ValidPolicy(p string) bool {
+	switch p {
+	case "", PolicyRoundRobin, PolicyLeastConn, PolicyWeighted, PolicySourceHash:
+		return true
+	}
+	return false
+}
+
+// dispatchTimeout is the time a picked client has to receive a user,
+// the user is dispatched to another client after it.
+const dispatchTimeout = 3 * time.Second
+
+type member struct {
+	c  *Client
+	ch chan net.Conn
+	// started is set by start, clients not running never receive users.
+	started bool
+	// current weight of smooth weighted round robin.
+	current int64
+}
+
+// balancer dispatches users accepted on a shared public listener to clients,
+// clients with unhealthy backend are skipped unless all are unhealthy.
+type balancer struct {
+	policy  string
+	members []*member
+	next    int
+	// done is closed by close, wg counts dispatches in flight so that
+	// channels of members are closed after them.
+	done   chan struct{}
+	closed bool
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+}
+
+func newBalancer(policy string) *balancer {
+	if policy == "" {
+		policy = PolicyRoundRobin
+	}
+	return &balancer{policy: policy, done: make(chan struct{})}
+}
+
+// add returns channel of users dispatched to c.
+func (b *balancer) add(c *Client) (chan net.Conn, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.lbPolicy != "" && c.lbPolicy != b.policy {
+		return nil, status.Errorf(codes.FailedPrecondition, "public port is balanced by %s", b.policy)
+	}
+	m := &member{c: c, ch: make(chan net.Conn)}
+	b.members = append(b.members, m)
+	return m.ch, nil
+}
+
+// start makes c receive users.
+func (b *balancer) start(c *Client) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, m := range b.members {
+		if m.c == c {
+			m.started = true
+		}
+	}
+}
+
+func (b *balancer) remove(c *Client) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for i, m := range b.members {
+		if m.c == c {
+			b.members = append(b.members[:i], b.members[i+1:]...)
+			return
+		}
+	}
+}
+
+// close closes channels of all members after the listener is closed.
+func (b *balancer) close() {
+	b.mu.Lock()
+	b.closed = true
+	close(b.done)
+	b.mu.Unlock()
+	b.wg.Wait()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, m := range b.members {
+		close(m.ch)
+	}
+	b.members = nil
+}
+
+// failoverConn is a user which is retried after clients in tried failed to serve it.
+type failoverConn struct {
+	net.Conn
+	tried []*Client
+}
+
+func (fc *failoverConn) hasTried(c *Client) bool {
+	for _, t := range fc.tried {
+		if t == c {
+			return true
+		}
+	}
+	return false
+}
+
+// failover hands conn to a client other than c and clients tried before,
+// conn is closed if there is no such client.
+func (b *balancer) failover(c *Client, conn net.Conn) bool {
+	fc, ok := conn.(*failoverConn)
+	if !ok {
+		fc = &failoverConn{Conn: conn}
+	}
+	fc.tried = append(fc.tried, c)
+	return b.dispatch(fc)
+}
+
+// dispatch hands conn to a client, conn is closed if there is no client.
+// A client which does not receive conn in dispatchTimeout is skipped.
+func (b *balancer) dispatch(conn net.Conn) bool {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		conn.Close()
+		return false
+	}
+	b.wg.Add(1)
+	b.mu.Unlock()
+	defer b.wg.Done()
+	for {
+		m := b.pick(conn)
+		if m == nil {
+			conn.Close()
+			return false
+		}
+		timer := time.NewTimer(dispatchTimeout)
+		select {
+		case m.ch <- conn:
+			timer.Stop()
+			return true
+		case <-m.c.done:
+			// client is closing, pick another one.
+			timer.Stop()
+			b.remove(m.c)
+		case <-timer.C:
+			log.Warnf("client %s does not receive user %s in %s, try another one", m.c.name, conn.RemoteAddr(), dispatchTimeout)
+			fc, ok := conn.(*failoverConn)
+			if !ok {
+				fc = &failoverConn{Conn: conn}
+			}
+			fc.tried = append(fc.tried, m.c)
+			conn = fc
+		case <-b.done:
+			timer.Stop()
+			conn.Close()
+			return false
+		}
+	}
+}
+
+func (b *balancer) pick(conn net.Conn) *member {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	fc, _ := conn.(*failoverConn)
+	members := []*member{}
+	for _, m := range b.members {
+		// detached clients have no stream to claim users.
+		if !m.started || m.c.detached() || fc != nil && fc.hasTried(m.c) {
+			continue
+		}
+		members = append(members, m)
+	}
+	candidates := []*member{}
+	for _, m := range members {
+		if m.c.Health().Healthy {
+			candidates = append(candidates, m)
+		}
+	}
+	if len(candidates) == 0 {
+		// the client rejects users as unhealthy.
+		candidates = members
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	switch b.policy {
+	case PolicyLeastConn:
+		picked := candidates[0]
+		for _, m := range candidates[1:] {
+			if atomic.LoadInt64(&m.c.users) < atomic.LoadInt64(&picked.c.users) {
+				picked = m
+			}
+		}
+		return picked
+	case PolicyWeighted:
+		// smooth weighted round robin of nginx.
+		var total int64
+		var picked *member
+		for _, m := range candidates {
+			w := m.c.weight
+			if w <= 0 {
+				w = 1
+			}
+			m.current += w
+			total += w
+			if picked == nil || m.current > picked.current {
+				picked = m
+			}
+		}
+		picked.current -= total
+		return picked
+	case PolicySourceHash:
+		addr := conn.RemoteAddr().String()
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			host = addr
+		}
+		h := fnv.New32a()
+		h.Write([]byte(host))
+		return candidates[h.Sum32()%uint32(len(candidates))]
+	}
+	b.next++
+	return candidates[b.next%len(candidates)]
+}
@@ -1,16 +1,35 @@
 package handler
 
 import (
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/hashicorp/yamux"
 	log "github.com/sirupsen/logrus"
+	"github.com/winglq/l4proxy/src/api"
+	"github.com/winglq/l4proxy/src/auth"
+	"github.com/winglq/l4proxy/src/metrics"
+	"github.com/winglq/l4proxy/src/proxyproto"
+	"golang.org/x/time/rate"
 )
 
 var cs = sync.Map{}
 
 type Client struct {
+	// lifetime bytes of all pairs, accessed atomically.
+	// keep them first to be 64-bit aligned on 32-bit platforms.
+	bytesIn            int64
+	bytesOut           int64
+	users              int64
+	rejected           int64
+	unclaimed          int64
+	maxUsers           int64
 	name               string
 	displayName        string
 	sharePub           bool
@@ -18,31 +37,165 @@ type Client struct {
 	intPort            string
 	host               string
 	connPairs          sync.Map
-	intConnCH          chan net.Conn
+	intConnCH          chan *dataConn
 	pubConnCH          chan net.Conn
 	done               chan struct{}
-	NewPubConnNotifyCH chan Token
+	NewPubConnNotifyCH chan *PubConnNotify
+	secrets            sync.Map
+	tlsConfig          *tls.Config
+	multiplex          bool
+	shareInt           bool
+	tunnelConnCH       chan net.Conn
+	owner              *auth.Key
 	wg                 sync.WaitGroup
 	logger             *log.Entry
 	backendHost        string
 	backendPort        string
 	protocol           string
+	udpIdleTimeout     time.Duration
+	quotas             *quotas
+	rateLimit          RateLimit
+	limitIn            *rate.Limiter
+	limitOut           *rate.Limiter
+	connLimiter        *connLimiter
+	// accessList is *AccessList which can be updated at runtime.
+	accessList       atomic.Value
+	serverAccessList *AccessList
+	acceptProxy      bool
+	// health is *api.Health reported by l4proxy client.
+	health   atomic.Value
+	lbPolicy string
+	weight   int64
+	// balancer of the shared public listener, nil if public port is not shared.
+	balancer *balancer
+	// pending pairs not claimed by l4proxy client in pairTimeout are closed.
+	pairTimeout time.Duration
+	expireCH    chan string
+	idleTimeout time.Duration
+	maxSession  time.Duration
+	keepAlive   time.Duration
+	// draining is closed by Drain, drained is closed when users are gone
+	// or drain timeout.
+	draining    chan struct{}
+	drained     chan struct{}
+	drainOnce   sync.Once
+	drainedOnce sync.Once
+	// sessionID resumes the client on a new stream, empty if not resumable.
+	sessionID string
+	// mu guards the stream attached and the detached state.
+	mu sync.Mutex
+	// stream is closed when another stream takes over the client.
+	stream chan struct{}
+	// detachCH is closed when the attached stream breaks.
+	detachCH chan struct{}
+	// detachTimer expires the client if no stream is attached in session grace,
+	// it is nil while a stream is attached.
+	detachTimer *time.Timer
+	onExpire    func()
+	expired     bool
 }
 
-func NewClient(name, displayName, host, pubPort, intPort string, sharePub bool, l *log.Entry) (*Client, error) {
+// PubConnNotify is sent when a new backend service user is connected.
+// l4proxy client should connect to internal address with token and secret.
+type PubConnNotify struct {
+	Token  Token
+	Secret string
+	// UserAddr is the remote address of the backend service user.
+	UserAddr string
+}
+
+type ClientOptions struct {
+	Name        string
+	DisplayName string
+	Host        string
+	PubPort     string
+	IntPort     string
+	SharePub    bool
+	// ShareInt means IntPort is the internal port shared by all clients,
+	// data connections are dispatched by client name in handshake.
+	ShareInt bool
+	// Multiplex means no internal listener is created, data connections
+	// are streams in the tunnel attached by ServeTunnel.
+	Multiplex bool
+	// TLSConfig is used to encrypt data connections if not nil.
+	TLSConfig *tls.Config
+	// Protocol of public listener, tcp or udp.
+	Protocol string
+	// UDPIdleTimeout is the idle time after which a udp session is closed.
+	UDPIdleTimeout time.Duration
+	// RateLimit of the client and each backend service user.
+	RateLimit RateLimit
+	// MaxUsers is the max concurrent backend service users, zero means unlimited.
+	MaxUsers int64
+	// AccessList of backend service users, can be updated by SetAccessList.
+	AccessList *AccessList
+	// LBPolicy balances users of the shared public port, all clients
+	// sharing the port must use the same policy.
+	LBPolicy string
+	// Weight of the client for weighted policy.
+	Weight int64
+	// PairTimeout is the time a backend service user waits for l4proxy client
+	// to connect internal port, zero means forever.
+	PairTimeout time.Duration
+	// IdleTimeout and MaxSessionDuration close paired connections which are idle
+	// or last too long, KeepAlive is the tcp keepalive period of public and internal
+	// connections, zero means no limit or system default.
+	IdleTimeout        time.Duration
+	MaxSessionDuration time.Duration
+	KeepAlive          time.Duration
+	// AcceptProxyProtocol means public tcp listener reads PROXY protocol header
+	// of each connection for address of backend service user.
+	AcceptProxyProtocol bool
+	// serverAccessList is from server config and can not be updated.
+	serverAccessList *AccessList
+	quotas           *quotas
+	connLimiter      *connLimiter
+}
+
+func NewClient(opt ClientOptions, l *log.Entry) (*Client, error) {
 	c := &Client{
-		name:               name,
-		displayName:        displayName,
-		pubPort:            pubPort,
-		intPort:            intPort,
-		host:               host,
+		name:               opt.Name,
+		displayName:        opt.DisplayName,
+		pubPort:            opt.PubPort,
+		intPort:            opt.IntPort,
+		host:               opt.Host,
 		done:               make(chan struct{}),
-		NewPubConnNotifyCH: make(chan Token),
-		sharePub:           sharePub,
+		NewPubConnNotifyCH: make(chan *PubConnNotify),
+		sharePub:           opt.SharePub,
+		shareInt:           opt.ShareInt,
+		tlsConfig:          opt.TLSConfig,
+		multiplex:          opt.Multiplex,
+		protocol:           opt.Protocol,
+		udpIdleTimeout:     opt.UDPIdleTimeout,
+		quotas:             opt.quotas,
+		rateLimit:          opt.RateLimit,
+		limitIn:            newLimiter(opt.RateLimit.In),
+		limitOut:           newLimiter(opt.RateLimit.Out),
+		maxUsers:           opt.MaxUsers,
+		connLimiter:        opt.connLimiter,
+		serverAccessList:   opt.serverAccessList,
+		acceptProxy:        opt.AcceptProxyProtocol,
+		lbPolicy:           opt.LBPolicy,
+		weight:             opt.Weight,
+		pairTimeout:        opt.PairTimeout,
+		expireCH:           make(chan string),
+		idleTimeout:        opt.IdleTimeout,
+		maxSession:         opt.MaxSessionDuration,
+		keepAlive:          opt.KeepAlive,
+		draining:           make(chan struct{}),
+		detachCH:           make(chan struct{}),
+		drained:            make(chan struct{}),
 		logger:             l,
 	}
+	c.health.Store(&api.Health{Healthy: true})
+	c.SetAccessList(opt.AccessList)
 	c.log().Infof("client connected")
-	return c, c.init()
+	if err := c.init(); err != nil {
+		// release listeners created before the failure.
+		close(c.done)
+		return nil, err
+	}
+	return c, nil
 }
 
 func (c *Client) SetSTUNInfo(host, backendPort, protocol string) {
@@ -66,12 +219,21 @@ func (c *Client) PubAddr() string {
 }
 
 func (c *Client) IntAddr() string {
-	if c.backendHost != "" {
+	if c.backendHost != "" || c.multiplex {
 		return ""
 	}
 	return net.JoinHostPort(c.host, c.intPort)
 }
 
+// IntTLS returns whether data connections are encrypted.
+func (c *Client) IntTLS() bool {
+	return c.tlsConfig != nil && !c.multiplex
+}
+
+func (c *Client) Multiplex() bool {
+	return c.multiplex
+}
+
 func (c *Client) PubBindAddr() string {
 	return net.JoinHostPort("", c.pubPort)
 }
@@ -84,9 +246,13 @@ func key(network, address string) string {
 	return strings.Join([]string{network, address}, "_")
 }
 
-func (c *Client) listenAndAccept(addr string, share bool) (string, chan net.Conn, error) {
+// listenAndAccept accepts connections on addr until the client is closed or stop is closed.
+func (c *Client) listenAndAccept(addr string, share bool, tlsConfig *tls.Config, acceptProxy bool, stop chan struct{}) (string, chan net.Conn, error) {
 	var ltn net.Listener
 	var err error
+	var ch chan net.Conn
+	// b dispatches users of a shared listener to clients.
+	var b *balancer
 	if share {
 		ltn, err = Listen("tcp", addr, func() {
 			cs.Delete(ltn)
@@ -94,61 +260,221 @@ func (c *Client) listenAndAccept(addr string, share bool) (string, chan net.Conn
 		if err != nil {
 			return "", nil, err
 		}
+		ib, ok := cs.Load(ltn)
+		if ok {
+			b = ib.(*balancer)
+		} else {
+			b = newBalancer(c.lbPolicy)
+		}
+		// listeners are closed before Close returns, so that the port can be reused.
+		c.wg.Add(1)
 		go func() {
+			defer c.wg.Done()
 			select {
 			case <-c.done:
+			case <-stop:
 			}
+			b.remove(c)
 			ltn.Close()
 		}()
-
-		ich, ok := cs.Load(ltn)
+		ch, err = b.add(c)
+		if err != nil {
+			return "", nil, err
+		}
+		c.balancer = b
 		if ok {
-
 			_, port, _ := net.SplitHostPort(addr)
-			return port, ich.(chan net.Conn), nil
+			return port, ch, nil
 		}
-
+		cs.Store(ltn, b)
 	} else {
 		ltn, err = net.Listen("tcp", addr)
 		if err != nil {
 			return "", nil, err
 		}
+		if tlsConfig != nil {
+			ltn = tls.NewListener(ltn, tlsConfig)
+		}
+		c.wg.Add(1)
 		go func() {
+			defer c.wg.Done()
 			select {
 			case <-c.done:
+			case <-stop:
 			}
 			ltn.Close()
 		}()
+		ch = make(chan net.Conn)
 	}
 
-	ch := make(chan net.Conn)
+	altn := ltn
+	if acceptProxy {
+		altn = proxyproto.NewListener(ltn, handshakeTimeout)
+	}
 	// this go routine will be closed when ltn(may be shared) closed.
 	// may we should use SharedListener to manage this go routine.
 	go func() {
-		defer close(ch)
+		if b != nil {
+			defer b.close()
+		} else {
+			defer close(ch)
+		}
 		for {
-			c, err := ltn.Accept()
+			c, err := altn.Accept()
 			if err != nil && strings.Contains(err.Error(), "use of closed network connection") {
 				return
 			} else if err != nil {
 				panic(err)
 			}
+			if b != nil {
+				// a client slow to receive must not block users of other clients.
+				go b.dispatch(c)
+				continue
+			}
 			ch <- c
 		}
 	}()
 	_, port, _ := net.SplitHostPort(ltn.Addr().String())
-	if share {
-		cs.Store(ltn, ch)
-	}
 	return port, ch, nil
 }
 
-func (c *Client) init() (err error) {
-	c.intPort, c.intConnCH, err = c.listenAndAccept(c.IntBindAddr(), false)
+// listenUDPAndAccept accepts each source address of udp public port as a connection
+// until the client is closed or stop is closed.
+func (c *Client) listenUDPAndAccept(addr string, stop chan struct{}) (string, chan net.Conn, error) {
+	if c.sharePub {
+		return "", nil, fmt.Errorf("share public port is not supported for udp")
+	}
+	ltn, err := listenUDP(addr, c.udpIdleTimeout)
 	if err != nil {
+		return "", nil, err
+	}
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		select {
+		case <-c.done:
+		case <-stop:
+			// existing sessions share the socket, it is kept until the client is closed.
+			ltn.stopAccept()
+			<-c.done
+		}
+		ltn.Close()
+	}()
+	_, port, _ := net.SplitHostPort(ltn.Addr().String())
+	return port, ltn.ch, nil
+}
+
+// listenSharedInternal registers the client to the demux of the shared internal listener.
+func (c *Client) listenSharedInternal(addr string) (chan *dataConn, error) {
+	var ltn net.Listener
+	var err error
+	ltn, err = Listen("tcp", addr, func() {
+		dms.Delete(ltn)
+	})
+	if err != nil {
+		return nil, err
+	}
+	var d *demux
+	if id, ok := dms.Load(ltn); ok {
+		d = id.(*demux)
+	} else {
+		d = newDemux(ltn, c.tlsConfig)
+		dms.Store(ltn, d)
+		go d.serve()
+	}
+	ch := make(chan *dataConn)
+	d.register(c.name, ch, c.done)
+	go func() {
+		<-c.done
+		d.unregister(c.name, c.done)
+		ltn.Close()
+	}()
+	return ch, nil
+}
+
+// acceptData reads handshake of data connections in ch.
+func (c *Client) acceptData(ch chan net.Conn) chan *dataConn {
+	out := make(chan *dataConn)
+	go func() {
+		for {
+			var conn net.Conn
+			select {
+			case conn = <-ch:
+			case <-c.done:
+				return
+			}
+			if conn == nil {
+				return
+			}
+			go func(conn net.Conn) {
+				dc, err := readHandshake(conn)
+				if err != nil {
+					c.log().Warnf("read handshake from %s failed: %v", conn.RemoteAddr(), err)
+					conn.Close()
+					return
+				}
+				select {
+				case out <- dc:
+				case <-c.done:
+					conn.Close()
+				}
+			}(conn)
+		}
+	}()
+	return out
+}
+
+// ServeTunnel accepts data connections from a multiplexed session
+// until the session or client is closed.
+func (c *Client) ServeTunnel(session *yamux.Session) error {
+	if !c.multiplex {
+		return fmt.Errorf("client %s is not multiplexed", c.name)
+	}
+	go func() {
+		select {
+		case <-c.done:
+		case <-session.CloseChan():
+		}
+		session.Close()
+	}()
+	c.log().Infof("tunnel attached")
+	for {
+		stream, err := session.Accept()
+		if err != nil {
+			c.log().Infof("tunnel detached: %v", err)
+			return nil
+		}
+		select {
+		case c.tunnelConnCH <- stream:
+		case <-c.done:
+			stream.Close()
+			return nil
+		}
+	}
+}
+
+func (c *Client) init() (err error) {
+	if c.multiplex {
+		c.tunnelConnCH = make(chan net.Conn)
+		c.intConnCH = c.acceptData(c.tunnelConnCH)
+	} else if c.shareInt {
+		c.intConnCH, err = c.listenSharedInternal(c.IntBindAddr())
+		if err != nil {
+			return
+		}
+	} else {
+		var intConnCH chan net.Conn
+		c.intPort, intConnCH, err = c.listenAndAccept(c.IntBindAddr(), false, c.tlsConfig, false, nil)
+		if err != nil {
+			return
+		}
+		c.intConnCH = c.acceptData(intConnCH)
+	}
+	if c.protocol == "udp" {
+		c.pubPort, c.pubConnCH, err = c.listenUDPAndAccept(c.PubBindAddr(), c.draining)
 		return
 	}
-	c.pubPort, c.pubConnCH, err = c.listenAndAccept(c.PubBindAddr(), c.sharePub)
+	c.pubPort, c.pubConnCH, err = c.listenAndAccept(c.PubBindAddr(), c.sharePub, nil, c.acceptProxy, c.draining)
 	if err != nil {
 		return
 	}
@@ -160,42 +486,134 @@ func (c *Client) Start() {
 	go func() {
 		c.run()
 	}()
+	if c.balancer != nil {
+		c.balancer.start(c)
+	}
 }
 
 func (c *Client) run() {
 	var token Token
 	defer c.wg.Done()
+	pubConnCH := c.pubConnCH
 	for {
 		select {
 		case <-c.done:
 			return
-		case conn := <-c.pubConnCH:
+		case conn := <-pubConnCH:
 			if conn == nil {
-				return
+				// public listener is closed by Drain, pending users are still paired.
+				pubConnCH = nil
+				continue
+			}
+			reason := c.admit(conn)
+			if (reason == rejectDraining || reason == rejectDetached) && c.balancer != nil {
+				// other clients sharing the port can serve it.
+				go c.balancer.failover(c, conn)
+				continue
+			}
+			if reason != "" {
+				atomic.AddInt64(&c.rejected, 1)
+				metrics.RejectedUsers.WithLabelValues(c.displayName, reason).Inc()
+				c.log().Debugf("backend service user from %s is rejected by %s", conn.RemoteAddr(), reason)
+				conn.Close()
+				continue
 			}
 			token = token + 1
-			c.connPairs.Store(token.String(), NewPairedConn(conn, nil))
-			c.NewPubConnNotifyCH <- token
+			secret := newSecret()
+			tk := token.String()
+			pair := NewPairedConn(conn, nil)
+			pair.OnClose = func() {
+				c.connPairs.Delete(tk)
+				c.release()
+			}
+			c.connPairs.Store(tk, pair)
+			c.secrets.Store(tk, secret)
+			if c.pairTimeout > 0 {
+				time.AfterFunc(c.pairTimeout, func() {
+					select {
+					case c.expireCH <- tk:
+					case <-c.done:
+					}
+				})
+			}
+			metrics.AcceptedUsers.WithLabelValues(c.displayName).Inc()
+			// blocks while no stream is attached until resumed or closed.
+			select {
+			case c.NewPubConnNotifyCH <- &PubConnNotify{
+				Token:    token,
+				Secret:   secret,
+				UserAddr: conn.RemoteAddr().String(),
+			}:
+			case <-c.detachChan():
+				// the stream broke before the user is sent to l4proxy client.
+				if conn, ok := pair.detach(); ok {
+					go c.handOver(tk, conn)
+				}
+				continue
+			case <-c.done:
+				return
+			}
 			c.log().Debugf("new backend service user from %s", conn.RemoteAddr())
+		case tk := <-c.expireCH:
+			// pairs are only copied in this goroutine, so pending is checked safely.
+			ipair, ok := c.connPairs.Load(tk)
+			if !ok {
+				continue
+			}
+			pair := ipair.(*PairedConn)
+			if pair.closePending() {
+				c.secrets.Delete(tk)
+				atomic.AddInt64(&c.unclaimed, 1)
+				metrics.UnclaimedUsers.WithLabelValues(c.displayName).Inc()
+				c.log().Debugf("backend service user %s is not claimed in %s", pair.SRC.RemoteAddr(), c.pairTimeout)
+			}
 		case conn := <-c.intConnCH:
 			if conn == nil {
 				return
 			}
-			buf := make([]byte, token.Len())
-			_, err := conn.Read(buf)
-			if err != nil {
-				panic(err)
+			// secret can be used only once.
+			secret, ok := c.secrets.Load(conn.token)
+			if !ok || conn.name != c.name || subtle.ConstantTimeCompare([]byte(secret.(string)), []byte(conn.secret)) != 1 {
+				c.log().Warnf("invalid data connection from %s", conn.RemoteAddr())
+				conn.Close()
+				continue
 			}
-			ipair, ok := c.connPairs.Load(string(buf))
+			c.secrets.Delete(conn.token)
+			ipair, ok := c.connPairs.Load(conn.token)
 			if !ok {
-				c.log().Warnf("%s does not exist in map", string(buf))
+				c.log().Warnf("%s does not exist in map", conn.token)
 				conn.Close()
 				continue
 			}
 			pair := ipair.(*PairedConn)
-			pair.DEST = conn
+			pair.DEST = conn.Conn
+			if c.protocol == "udp" {
+				pair.DEST = NewFramedConn(conn.Conn)
+			}
+			bytesIn := metrics.BytesIn.WithLabelValues(c.displayName)
+			bytesOut := metrics.BytesOut.WithLabelValues(c.displayName)
+			pair.OnBytesIn = func(n int) {
+				bytesIn.Add(float64(n))
+				atomic.AddInt64(&c.bytesIn, int64(n))
+				c.quotas.add(c.displayName, n)
+			}
+			pair.OnBytesOut = func(n int) {
+				bytesOut.Add(float64(n))
+				atomic.AddInt64(&c.bytesOut, int64(n))
+				c.quotas.add(c.displayName, n)
+			}
+			pair.LimitIn = limiters(c.limitIn, newLimiter(c.rateLimit.UserIn))
+			pair.LimitOut = limiters(c.limitOut, newLimiter(c.rateLimit.UserOut))
+			pair.IdleTimeout = c.idleTimeout
+			pair.MaxDuration = c.maxSession
+			SetKeepAlive(pair.SRC, c.keepAlive)
+			SetKeepAlive(conn.Conn, c.keepAlive)
+			active := metrics.ActiveConnections.WithLabelValues(c.displayName)
+			active.Inc()
+			release := pair.OnClose
 			pair.OnClose = func() {
-				c.connPairs.Delete(string(buf))
+				active.Dec()
+				release()
 				c.log().Debugf("backend service user %s disconnected", pair.SRC.RemoteAddr())
 			}
 			pair.Copy()
@@ -204,6 +622,241 @@ func (c *Client) run() {
 	}
 }
 
+// admit checks limits of a new backend service user, returns the reason if rejected.
+// release must be called when an admitted user is gone.
+func (c *Client) admit(conn net.Conn) string {
+	if c.Draining() {
+		return rejectDraining
+	}
+	if c.detached() {
+		return rejectDetached
+	}
+	if !c.Health().Healthy {
+		return rejectUnhealthy
+	}
+	if !c.serverAccessList.allowed(conn.RemoteAddr()) || !c.AccessList().allowed(conn.RemoteAddr()) {
+		return rejectAccessList
+	}
+	// retried users are counted by ip rate limit already.
+	if _, retried := conn.(*failoverConn); !retried && !c.connLimiter.allowIP(conn.RemoteAddr()) {
+		return rejectIPRate
+	}
+	if c.quotas.exceeded(c.displayName) {
+		return rejectQuota
+	}
+	if n := atomic.AddInt64(&c.users, 1); c.maxUsers > 0 && n > c.maxUsers {
+		c.leave()
+		return rejectMaxUsers
+	}
+	if !c.connLimiter.acquire() {
+		c.leave()
+		return rejectMaxConns
+	}
+	return ""
+}
+
+func (c *Client) release() {
+	c.leave()
+	c.connLimiter.release()
+}
+
+// leave decreases users, the draining client is drained when no user is left.
+func (c *Client) leave() {
+	if atomic.AddInt64(&c.users, -1) == 0 && c.Draining() {
+		c.setDrained()
+	}
+}
+
+// Drain stops accepting backend service users, Drained is closed when existing
+// users are gone or after timeout, zero means no timeout.
+func (c *Client) Drain(timeout time.Duration) {
+	c.drainOnce.Do(func() {
+		close(c.draining)
+		c.log().Infof("draining %d backend service users", c.Users())
+		if timeout > 0 {
+			time.AfterFunc(timeout, c.setDrained)
+		}
+		if c.Users() == 0 {
+			c.setDrained()
+		}
+	})
+}
+
+func (c *Client) setDrained() {
+	c.drainedOnce.Do(func() {
+		close(c.drained)
+	})
+}
+
+// Draining reports whether Drain is called.
+func (c *Client) Draining() bool {
+	select {
+	case <-c.draining:
+		return true
+	default:
+		return false
+	}
+}
+
+// Drained is closed when the draining client can be closed.
+func (c *Client) Drained() <-chan struct{} {
+	return c.drained
+}
+
+// attach attaches a new stream to the client, the returned channel is closed
+// when another stream takes over. It fails if the client is expired.
+func (c *Client) attach() (chan struct{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.expired {
+		return nil, false
+	}
+	if c.detachTimer != nil {
+		c.detachTimer.Stop()
+		c.detachTimer = nil
+		c.detachCH = make(chan struct{})
+	}
+	if c.stream != nil {
+		close(c.stream)
+	}
+	c.stream = make(chan struct{})
+	return c.stream, true
+}
+
+// detach detaches the broken stream, onExpire is called if no stream is attached
+// in grace. It does nothing if the stream is taken over.
+func (c *Client) detach(stream chan struct{}, grace time.Duration, onExpire func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stream != stream || c.expired {
+		return
+	}
+	c.stream = nil
+	close(c.detachCH)
+	c.onExpire = onExpire
+	var t *time.Timer
+	t = time.AfterFunc(grace, func() {
+		c.mu.Lock()
+		if c.detachTimer != t {
+			// attached again.
+			c.mu.Unlock()
+			return
+		}
+		c.mu.Unlock()
+		c.expire()
+	})
+	c.detachTimer = t
+}
+
+// expire calls onExpire of the detached client, reports whether it is expired by this call.
+func (c *Client) expire() bool {
+	c.mu.Lock()
+	if c.detachTimer == nil || c.expired {
+		c.mu.Unlock()
+		return false
+	}
+	c.detachTimer.Stop()
+	c.detachTimer = nil
+	c.expired = true
+	c.mu.Unlock()
+	c.onExpire()
+	return true
+}
+
+func (c *Client) detachChan() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.detachCH
+}
+
+func (c *Client) detached() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.detachTimer != nil
+}
+
+// Users returns number of backend service users being served or waiting for pairing.
+func (c *Client) Users() int64 {
+	return atomic.LoadInt64(&c.users)
+}
+
+// SetAccessList replaces access list of backend service users, nil allows all.
+func (c *Client) SetAccessList(l *AccessList) {
+	c.accessList.Store(l)
+}
+
+func (c *Client) AccessList() *AccessList {
+	return c.accessList.Load().(*AccessList)
+}
+
+// Failover handles the failure of l4proxy client serving the backend service user of token,
+// the user is handed to another client sharing the public port or closed.
+func (c *Client) Failover(token, secret string) (bool, error) {
+	expected, ok := c.secrets.Load(token)
+	if !ok || subtle.ConstantTimeCompare([]byte(expected.(string)), []byte(secret)) != 1 {
+		return false, fmt.Errorf("invalid token or secret")
+	}
+	c.secrets.Delete(token)
+	metrics.DialFailures.WithLabelValues(c.displayName, "backend").Inc()
+	ipair, ok := c.connPairs.Load(token)
+	if !ok {
+		return false, fmt.Errorf("%s does not exist", token)
+	}
+	conn, ok := ipair.(*PairedConn).detach()
+	if !ok {
+		return false, fmt.Errorf("%s is already paired", token)
+	}
+	return c.handOver(token, conn), nil
+}
+
+// handOver hands the pending user of token whose pair is detached to another client
+// sharing the public port, or closes it. It reports whether the user is handed over.
+func (c *Client) handOver(token string, conn net.Conn) bool {
+	c.connPairs.Delete(token)
+	c.secrets.Delete(token)
+	c.release()
+	if c.balancer == nil {
+		c.log().Debugf("backend service user %s is closed", conn.RemoteAddr())
+		conn.Close()
+		return false
+	}
+	retried := c.balancer.failover(c, conn)
+	c.log().Debugf("backend service user %s is retried on another client: %t", conn.RemoteAddr(), retried)
+	return retried
+}
+
+// SetHealth updates health of the backend reported by l4proxy client.
+func (c *Client) SetHealth(h *api.Health) {
+	if old := c.Health(); old.Healthy != h.Healthy {
+		c.log().Infof("backend healthy changed to %t: %s", h.Healthy, h.Detail)
+	}
+	c.health.Store(h)
+}
+
+func (c *Client) Health() *api.Health {
+	return c.health.Load().(*api.Health)
+}
+
+// Unclaimed returns number of backend service users closed for pair timeout.
+func (c *Client) Unclaimed() int64 {
+	return atomic.LoadInt64(&c.unclaimed)
+}
+
+// Rejected returns number of backend service users rejected by limits.
+func (c *Client) Rejected() int64 {
+	return atomic.LoadInt64(&c.rejected)
+}
+
+// BytesIn returns lifetime bytes from backend service users to backends.
+func (c *Client) BytesIn() int64 {
+	return atomic.LoadInt64(&c.bytesIn)
+}
+
+// BytesOut returns lifetime bytes from backends to backend service users.
+func (c *Client) BytesOut() int64 {
+	return atomic.LoadInt64(&c.bytesOut)
+}
+
 func (c *Client) Close() {
 	c.connPairs.Range(func(k, v interface{}) bool {
 		v.(*PairedConn).Close()
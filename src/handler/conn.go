@@ -1,25 +1,51 @@
 package handler
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
 )
 
 type PairedConn struct {
+	// lifetime bytes copied, accessed atomically.
+	// keep them first to be 64-bit aligned on 32-bit platforms.
+	bytesIn  int64
+	bytesOut int64
+	// unix nano of the last bytes copied in either direction.
+	lastActive int64
+	// rates of bytes copied, updated by stats.
+	rateIn  ewma
+	rateOut ewma
+	// started is set to 1 by Copy or Close, accessed atomically.
+	started   int32
 	SRC, DEST net.Conn
-	SpeedIn   float64
-	SpeedOut  float64
-	OnClose   func()
-	chIn      chan int
-	chOut     chan int
-	wg        sync.WaitGroup
-	done      chan struct{}
-	once      sync.Once
+	// OnClose is called once when the pair is closed, even if Copy is never called.
+	OnClose func()
+	// OnBytesIn and OnBytesOut are called with bytes copied from SRC to DEST
+	// and from DEST to SRC if not nil.
+	OnBytesIn  func(n int)
+	OnBytesOut func(n int)
+	// IdleTimeout closes the pair if no bytes are copied in either direction,
+	// MaxDuration closes the pair after copied for the duration, zero means no limit.
+	// They must be set before Copy.
+	IdleTimeout time.Duration
+	MaxDuration time.Duration
+	// LimitIn and LimitOut limit bandwidth from SRC to DEST
+	// and from DEST to SRC, must be set before Copy.
+	LimitIn  []*rate.Limiter
+	LimitOut []*rate.Limiter
+	wg       sync.WaitGroup
+	done     chan struct{}
+	ctx      context.Context
+	cancel   context.CancelFunc
+	once     sync.Once
 }
 
 type Token int
@@ -32,21 +58,26 @@ var pool = sync.Pool{
 }
 
 // copyBuffer is copied from golang's std lib.
-func copyBuffer(src, dst net.Conn, buf []byte, speedCH chan<- int, done <-chan struct{}) (written int64, err error) {
+// Each write waits for limiters and bytes written are passed to account.
+func copyBuffer(ctx context.Context, src, dst net.Conn, buf []byte, limiters []*rate.Limiter, account func(n int)) (written int64, err error) {
 	if buf != nil && len(buf) == 0 {
 		panic("empty buffer in io.CopyBuffer")
 	}
 	for {
 		select {
-		case <-done:
+		case <-ctx.Done():
 			return
 		default:
 		}
 		nr, er := src.Read(buf)
 		if nr > 0 {
+			if err = waitN(ctx, limiters, nr); err != nil {
+				break
+			}
 			nw, ew := dst.Write(buf[0:nr])
 			if nw > 0 {
 				written += int64(nw)
+				account(nw)
 			}
 			if ew != nil {
 				err = ew
@@ -63,113 +94,182 @@ func copyBuffer(src, dst net.Conn, buf []byte, speedCH chan<- int, done <-chan s
 			}
 			break
 		}
-		speedCH <- nr
 	}
 	return written, err
 }
 
 func NewPairedConn(src, dst net.Conn) *PairedConn {
+	ctx, cancel := context.WithCancel(context.Background())
 	p := &PairedConn{
-		SRC:   src,
-		DEST:  dst,
-		chIn:  make(chan int),
-		chOut: make(chan int),
-		done:  make(chan struct{}),
-	}
-	p.wg.Add(1)
-	go p.statistic()
+		SRC:    src,
+		DEST:   dst,
+		done:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
 	return p
 }
 
-func (pc *PairedConn) statistic() {
-	defer pc.wg.Done()
-	seconds := 5.0
-	t := time.NewTicker(time.Duration(seconds) * time.Second)
-	type SpeedPair struct {
-		speed float64
-		t     time.Time
-	}
-	speedsIn := []SpeedPair{}
-	speedsOut := []SpeedPair{}
-	for {
-		select {
-		case nr, ok := <-pc.chIn:
-			if !ok {
-				return
-			}
-			speedsIn = append(speedsIn, SpeedPair{
-				speed: float64(nr),
-				t:     time.Now(),
-			})
-		case nr, ok := <-pc.chOut:
-			if !ok {
-				return
-			}
-			speedsOut = append(speedsOut, SpeedPair{
-				speed: float64(nr),
-				t:     time.Now(),
-			})
-
-		case <-t.C:
-			fn := func(speeds []SpeedPair) float64 {
-				total := 0.0
-				for _, sp := range speeds {
-					total += sp.speed
-				}
-				return total / seconds
-
-			}
-			pc.SpeedIn = fn(speedsIn)
-			pc.SpeedOut = fn(speedsOut)
-			speedsIn = []SpeedPair{}
-			speedsOut = []SpeedPair{}
-		case <-pc.done:
-			return
+// account adds n bytes copied to counter and calls onBytes if not nil.
+func (pc *PairedConn) account(counter *int64, onBytes func(n int)) func(n int) {
+	return func(n int) {
+		atomic.AddInt64(counter, int64(n))
+		atomic.StoreInt64(&pc.lastActive, time.Now().UnixNano())
+		if onBytes != nil {
+			onBytes(n)
 		}
 	}
 }
 
-func (pc *PairedConn) copy(src, dst net.Conn, ch chan<- int) (int64, error) {
-	buf := pool.Get().([]byte)
-	defer pool.Put(buf)
+// copy copies src to dst, it takes the splice fast path if both are tcp connections.
+func (pc *PairedConn) copy(src, dst net.Conn, limiters []*rate.Limiter, account func(n int)) (int64, error) {
+	tcpSrc, ok1 := tcpConn(src)
+	tcpDst, ok2 := tcpConn(dst)
+	if ok1 && ok2 {
+		if n, handled, err := spliceCopy(pc.ctx, tcpSrc, tcpDst, limiters, account); handled {
+			return n, err
+		}
+	}
+	p := &pool
+	_, srcPacket := src.(packetConn)
+	_, dstPacket := dst.(packetConn)
+	if srcPacket || dstPacket {
+		p = &packetPool
+	}
+	buf := p.Get().([]byte)
+	defer p.Put(buf)
 
-	return copyBuffer(src, dst, buf, ch, pc.done)
+	return copyBuffer(pc.ctx, src, dst, buf, limiters, account)
 }
 
 func (pc *PairedConn) Copy() {
-	gracefulClosed := false
+	if !atomic.CompareAndSwapInt32(&pc.started, 0, 1) {
+		// closed before copy.
+		return
+	}
+	stats.add(pc)
+	// the pair is closed when both directions reach EOF, or either fails.
+	remaining := int32(2)
+	finish := func(src, dst net.Conn, err error) {
+		if err != nil && pc.ctx.Err() == nil {
+			log.Printf("copy %s -> %s failed: %v", src.RemoteAddr(), dst.LocalAddr(), err)
+		}
+		// EOF is passed to dst by half-close, so that dst can still send.
+		if err != nil || pc.ctx.Err() != nil || !closeWrite(dst) || atomic.AddInt32(&remaining, -1) == 0 {
+			pc.close()
+		}
+	}
 	pc.wg.Add(1)
 	go func() {
 		defer pc.wg.Done()
-		_, err := pc.copy(pc.SRC, pc.DEST, pc.chIn)
-		if err != nil && !gracefulClosed {
-			log.Printf("copy %s -> %s failed: %v", pc.SRC.RemoteAddr(), pc.DEST.LocalAddr(), err)
-		} else {
-			gracefulClosed = true
-		}
-		pc.close()
+		_, err := pc.copy(pc.SRC, pc.DEST, pc.LimitIn, pc.account(&pc.bytesIn, pc.OnBytesIn))
+		finish(pc.SRC, pc.DEST, err)
 	}()
 	pc.wg.Add(1)
 	go func() {
 		defer pc.wg.Done()
-		_, err := pc.copy(pc.DEST, pc.SRC, pc.chOut)
-		if err != nil && !gracefulClosed {
-			log.Printf("copy %s -> %s failed: %v", pc.DEST.LocalAddr(), pc.SRC.RemoteAddr(), err)
-		} else {
-			gracefulClosed = true
-		}
-		pc.close()
+		_, err := pc.copy(pc.DEST, pc.SRC, pc.LimitOut, pc.account(&pc.bytesOut, pc.OnBytesOut))
+		finish(pc.DEST, pc.SRC, err)
 	}()
 	pc.wg.Add(1)
 	go func() {
 		defer pc.wg.Done()
 		<-pc.done
-		if pc.OnClose != nil {
-			pc.OnClose()
+		stats.remove(pc)
+		pc.release()
+	}()
+	if pc.IdleTimeout > 0 || pc.MaxDuration > 0 {
+		pc.wg.Add(1)
+		go pc.watch()
+	}
+}
+
+// watch closes the pair when it is idle for IdleTimeout or lasts for MaxDuration.
+func (pc *PairedConn) watch() {
+	defer pc.wg.Done()
+	atomic.StoreInt64(&pc.lastActive, time.Now().UnixNano())
+	var maxC, idleC <-chan time.Time
+	if pc.MaxDuration > 0 {
+		t := time.NewTimer(pc.MaxDuration)
+		defer t.Stop()
+		maxC = t.C
+	}
+	var idle *time.Timer
+	if pc.IdleTimeout > 0 {
+		idle = time.NewTimer(pc.IdleTimeout)
+		defer idle.Stop()
+		idleC = idle.C
+	}
+	for {
+		select {
+		case <-pc.done:
+			return
+		case <-maxC:
+			log.Debugf("pair %s -> %s reaches max duration %s", pc.SRC.RemoteAddr(), pc.DEST.LocalAddr(), pc.MaxDuration)
+			pc.close()
+			return
+		case <-idleC:
+			d := time.Since(time.Unix(0, atomic.LoadInt64(&pc.lastActive)))
+			if d >= pc.IdleTimeout {
+				log.Debugf("pair %s -> %s is idle for %s", pc.SRC.RemoteAddr(), pc.DEST.LocalAddr(), d)
+				pc.close()
+				return
+			}
+			idle.Reset(pc.IdleTimeout - d)
 		}
-		pc.SRC.Close()
+	}
+}
+
+func (pc *PairedConn) release() {
+	if pc.OnClose != nil {
+		pc.OnClose()
+	}
+	pc.SRC.Close()
+	if pc.DEST != nil {
 		pc.DEST.Close()
-	}()
+	}
+}
+
+// BytesIn returns lifetime bytes copied from SRC to DEST.
+func (pc *PairedConn) BytesIn() int64 {
+	return atomic.LoadInt64(&pc.bytesIn)
+}
+
+// BytesOut returns lifetime bytes copied from DEST to SRC.
+func (pc *PairedConn) BytesOut() int64 {
+	return atomic.LoadInt64(&pc.bytesOut)
+}
+
+// SpeedIn returns bytes per second copied from SRC to DEST recently.
+func (pc *PairedConn) SpeedIn() float64 {
+	return pc.rateIn.rate()
+}
+
+// SpeedOut returns bytes per second copied from DEST to SRC recently.
+func (pc *PairedConn) SpeedOut() float64 {
+	return pc.rateOut.rate()
+}
+
+// closePending closes a pair which is never copied, reports whether it is closed.
+func (pc *PairedConn) closePending() bool {
+	if !atomic.CompareAndSwapInt32(&pc.started, 0, 1) {
+		return false
+	}
+	pc.close()
+	pc.release()
+	pc.wg.Wait()
+	return true
+}
+
+// detach stops a pair which is never copied and returns SRC without closing it,
+// OnClose is not called.
+func (pc *PairedConn) detach() (net.Conn, bool) {
+	if !atomic.CompareAndSwapInt32(&pc.started, 0, 1) {
+		return nil, false
+	}
+	pc.close()
+	pc.wg.Wait()
+	return pc.SRC, true
 }
 
 func (pc *PairedConn) String() string {
@@ -178,12 +278,17 @@ func (pc *PairedConn) String() string {
 
 func (pc *PairedConn) Close() {
 	pc.close()
+	if atomic.CompareAndSwapInt32(&pc.started, 0, 1) {
+		// Copy is never called, e.g. a backend service user waiting for pairing.
+		pc.release()
+	}
 	pc.wg.Wait()
 	log.Debug("pair closed")
 }
 
 func (pc *PairedConn) close() {
 	pc.once.Do(func() {
+		pc.cancel()
 		close(pc.done)
 	})
 }
@@ -192,6 +297,54 @@ func (t Token) String() string {
 	return fmt.Sprintf("%04d", int(t))
 }
 
-func (t Token) Len() int {
-	return 4
+// tcpConn returns the tcp connection wrapped by conn,
+// if reading or writing conn is the same as the tcp connection.
+func tcpConn(conn net.Conn) (*net.TCPConn, bool) {
+	switch c := conn.(type) {
+	case *net.TCPConn:
+		return c, true
+	case *dataConn:
+		return tcpConn(c.Conn)
+	case *failoverConn:
+		return tcpConn(c.Conn)
+	}
+	return nil, false
+}
+
+// closeWrite shuts down the writing side of conn or the connection wrapped by conn,
+// reports whether conn supports half-close.
+func closeWrite(conn net.Conn) bool {
+	switch c := conn.(type) {
+	case interface{ CloseWrite() error }:
+		// tcp and tls connections. a stream of tunnel can not be read after
+		// closed, it does not support half-close.
+		return c.CloseWrite() == nil
+	case *dataConn:
+		return closeWrite(c.Conn)
+	case *failoverConn:
+		return closeWrite(c.Conn)
+	case interface{ NetConn() net.Conn }:
+		return closeWrite(c.NetConn())
+	}
+	return false
+}
+
+// SetKeepAlive sets tcp keepalive period of conn or the tcp connection wrapped by conn,
+// it does nothing if period is zero or conn is not tcp, e.g. a stream of tunnel.
+func SetKeepAlive(conn net.Conn, period time.Duration) {
+	if period <= 0 {
+		return
+	}
+	switch c := conn.(type) {
+	case *net.TCPConn:
+		c.SetKeepAlive(true)
+		c.SetKeepAlivePeriod(period)
+	case *dataConn:
+		SetKeepAlive(c.Conn, period)
+	case *failoverConn:
+		SetKeepAlive(c.Conn, period)
+	case interface{ NetConn() net.Conn }:
+		// tls and PROXY protocol connections.
+		SetKeepAlive(c.NetConn(), period)
+	}
 }
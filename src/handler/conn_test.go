@@ -0,0 +1,149 @@
+//go:build linux
+// +build linux
+
+package handler
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// plainConn hides the tcp connection to force the buffered copy.
+type plainConn struct {
+	net.Conn
+}
+
+func tcpPipe(b testing.TB) (net.Conn, net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer ln.Close()
+	ch := make(chan net.Conn)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			b.Error(err)
+		}
+		ch <- c
+	}()
+	c, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+	return c, <-ch
+}
+
+func cpuTime() time.Duration {
+	var ru syscall.Rusage
+	syscall.Getrusage(syscall.RUSAGE_SELF, &ru)
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+// benchmarkCopy sends bytes from user through a pair to the backend,
+// it reports cpu time of the process besides throughput.
+func benchmarkCopy(b *testing.B, wrap func(net.Conn) net.Conn) {
+	user, src := tcpPipe(b)
+	dst, backend := tcpPipe(b)
+	defer user.Close()
+	defer backend.Close()
+	pc := NewPairedConn(wrap(src), wrap(dst))
+	pc.Copy()
+	defer pc.Close()
+
+	received := make(chan int64)
+	go func() {
+		n, _ := io.Copy(ioutil.Discard, backend)
+		received <- n
+	}()
+	buf := make([]byte, 128*1024)
+	b.SetBytes(int64(len(buf)))
+	b.ResetTimer()
+	cpu := cpuTime()
+	for i := 0; i < b.N; i++ {
+		if _, err := user.Write(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+	user.(*net.TCPConn).CloseWrite()
+	n := <-received
+	b.StopTimer()
+	b.ReportMetric(float64(cpuTime()-cpu)/float64(b.N), "cpu-ns/op")
+	if want := int64(b.N) * int64(len(buf)); n != want || pc.BytesIn() != want {
+		b.Fatalf("received %d bytes, accounted %d bytes, want %d", n, pc.BytesIn(), want)
+	}
+}
+
+func BenchmarkCopySplice(b *testing.B) {
+	benchmarkCopy(b, func(c net.Conn) net.Conn { return c })
+}
+
+func BenchmarkCopyBuffer(b *testing.B) {
+	benchmarkCopy(b, func(c net.Conn) net.Conn { return &plainConn{c} })
+}
+
+// TestCopyAccountsBytes checks bytes are accounted as soon as they are copied,
+// not after a whole splice chunk.
+func TestCopyAccountsBytes(t *testing.T) {
+	for name, wrap := range map[string]func(net.Conn) net.Conn{
+		"splice": func(c net.Conn) net.Conn { return c },
+		"buffer": func(c net.Conn) net.Conn { return &plainConn{c} },
+	} {
+		t.Run(name, func(t *testing.T) {
+			user, src := tcpPipe(t)
+			dst, backend := tcpPipe(t)
+			defer user.Close()
+			defer backend.Close()
+			pc := NewPairedConn(wrap(src), wrap(dst))
+			pc.Copy()
+			defer pc.Close()
+
+			if _, err := user.Write(make([]byte, 1000)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := io.ReadFull(backend, make([]byte, 1000)); err != nil {
+				t.Fatal(err)
+			}
+			deadline := time.Now().Add(time.Second)
+			for pc.BytesIn() != 1000 && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if n := pc.BytesIn(); n != 1000 {
+				t.Fatalf("accounted %d bytes, want 1000", n)
+			}
+		})
+	}
+}
+
+func TestSpliceCopyLimitedByBurst(t *testing.T) {
+	user, src := tcpPipe(t)
+	dst, backend := tcpPipe(t)
+	defer src.Close()
+	defer dst.Close()
+	defer backend.Close()
+	go func() {
+		user.Write(make([]byte, 5000))
+		user.Close()
+	}()
+	go io.Copy(ioutil.Discard, backend)
+	l := rate.NewLimiter(rate.Limit(1e6), 1000)
+	most := 0
+	written, handled, err := spliceCopy(context.Background(), src.(*net.TCPConn), dst.(*net.TCPConn), []*rate.Limiter{l}, func(n int) {
+		if n > most {
+			most = n
+		}
+	})
+	if !handled || err != nil || written != 5000 {
+		t.Fatalf("written %d, handled %v, err %v", written, handled, err)
+	}
+	if most > l.Burst() {
+		t.Fatalf("spliced %d bytes at a time, more than burst %d", most, l.Burst())
+	}
+}
@@ -2,11 +2,14 @@ package handler
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"math/rand"
 	"net"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/elazarl/goproxy"
@@ -14,34 +17,224 @@ import (
 	uuid "github.com/satori/go.uuid"
 	log "github.com/sirupsen/logrus"
 	"github.com/winglq/l4proxy/src/api"
+	"github.com/winglq/l4proxy/src/auth"
+	"github.com/winglq/l4proxy/src/metrics"
+	"github.com/winglq/l4proxy/src/tunnel"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 )
 
 type Handler struct {
-	host     string
-	clients  sync.Map
-	services sync.Map
+	host              string
+	intPort           string
+	dataTLS           *tls.Config
+	udpIdleTimeout    time.Duration
+	pubPortMin        int32
+	pubPortMax        int32
+	quotas            *quotas
+	rateLimits        map[string]RateLimit
+	maxUsersPerClient int64
+	accessLists       map[string]*AccessList
+	acceptProxy       bool
+	pairTimeout       time.Duration
+	idleTimeout       time.Duration
+	maxSession        time.Duration
+	keepAlive         time.Duration
+	drainTimeout      time.Duration
+	sessionGrace      time.Duration
+	connLimiter       *connLimiter
+	clients           sync.Map
+	services          sync.Map
+	// sessions are resumable clients by session id.
+	sessions sync.Map
+	// draining is set to 1 by Drain, accessed atomically.
+	draining int32
 }
 
-func New(host string) *Handler {
+type Options struct {
+	// Host is the public host ip address or hostname.
+	Host string
+	// IntPort is the internal port shared by all clients, each client
+	// listens on its own internal port if empty.
+	IntPort string
+	// DataTLS is used to encrypt data connections from l4proxy clients, plain tcp is used if nil.
+	DataTLS *tls.Config
+	// UDPIdleTimeout is the idle time after which a udp session is closed.
+	UDPIdleTimeout time.Duration
+	// PubPortMin and PubPortMax pin public ports of clients in a range if not zero.
+	PubPortMin int32
+	PubPortMax int32
+	// Quotas are byte quotas by display name, new backend service users
+	// are refused once quota is exceeded.
+	Quotas map[string]Quota
+	// QuotaStateFile keeps quota usage across restarts, usage is reset
+	// when server restarts if empty.
+	QuotaStateFile string
+	// RateLimits by display name take precedence over rate limits in requests.
+	RateLimits map[string]RateLimit
+	// MaxUsers is the server-wide max concurrent backend service users,
+	// MaxUsersPerClient caps max users requested by clients and
+	// NewConnsPerIP is max new connections per second from a source ip.
+	// Zero means unlimited.
+	MaxUsers          int64
+	MaxUsersPerClient int64
+	NewConnsPerIP     float64
+	// AccessLists by display name are checked in addition to access lists in requests.
+	AccessLists map[string]*AccessList
+	// AcceptProxyProtocol means public tcp listeners are behind a load balancer
+	// which sends PROXY protocol header, users without header are refused.
+	AcceptProxyProtocol bool
+	// PairTimeout closes backend service users not claimed by l4proxy client, zero means forever.
+	PairTimeout time.Duration
+	// defaults of clients which do not set them in request, zero means no limit
+	// or system default.
+	IdleTimeout        time.Duration
+	MaxSessionDuration time.Duration
+	KeepAlive          time.Duration
+	// DrainTimeout is the time draining clients wait for existing users,
+	// zero means forever.
+	DrainTimeout time.Duration
+	// SessionGrace is the time a client is kept for resumption after its stream breaks,
+	// zero means the client is closed at once.
+	SessionGrace time.Duration
+}
+
+func New(opt Options) *Handler {
 	h := &Handler{
-		host: host,
+		host:              opt.Host,
+		intPort:           opt.IntPort,
+		dataTLS:           opt.DataTLS,
+		udpIdleTimeout:    opt.UDPIdleTimeout,
+		pubPortMin:        opt.PubPortMin,
+		pubPortMax:        opt.PubPortMax,
+		quotas:            newQuotas(opt.Quotas, opt.QuotaStateFile),
+		rateLimits:        opt.RateLimits,
+		maxUsersPerClient: opt.MaxUsersPerClient,
+		accessLists:       opt.AccessLists,
+		acceptProxy:       opt.AcceptProxyProtocol,
+		pairTimeout:       opt.PairTimeout,
+		idleTimeout:       opt.IdleTimeout,
+		maxSession:        opt.MaxSessionDuration,
+		keepAlive:         opt.KeepAlive,
+		drainTimeout:      opt.DrainTimeout,
+		sessionGrace:      opt.SessionGrace,
+	}
+	if opt.MaxUsers > 0 || opt.NewConnsPerIP > 0 {
+		h.connLimiter = newConnLimiter(opt.MaxUsers, opt.NewConnsPerIP)
 	}
 	return h
 }
 
+// newClient creates client on the requested public port, or on the first
+// free port in public port range if the requested port is 0.
+func (h *Handler) newClient(opt ClientOptions, pubPort int32, l *log.Entry) (*Client, error) {
+	if h.pubPortMin == 0 {
+		opt.PubPort = fmt.Sprintf("%d", pubPort)
+		return NewClient(opt, l)
+	}
+	if pubPort != 0 {
+		if pubPort < h.pubPortMin || pubPort > h.pubPortMax {
+			return nil, status.Errorf(codes.InvalidArgument, "public port %d is out of range %d-%d", pubPort, h.pubPortMin, h.pubPortMax)
+		}
+		opt.PubPort = fmt.Sprintf("%d", pubPort)
+		return NewClient(opt, l)
+	}
+	n := h.pubPortMax - h.pubPortMin + 1
+	start := rand.Int31n(n)
+	for i := int32(0); i < n; i++ {
+		opt.PubPort = fmt.Sprintf("%d", h.pubPortMin+(start+i)%n)
+		c, err := NewClient(opt, l)
+		if err == nil {
+			return c, nil
+		}
+	}
+	return nil, status.Errorf(codes.ResourceExhausted, "no free public port in range %d-%d", h.pubPortMin, h.pubPortMax)
+}
+
+// secondsOr returns s seconds, or d if s is not positive.
+func secondsOr(s int32, d time.Duration) time.Duration {
+	if s <= 0 {
+		return d
+	}
+	return time.Duration(s) * time.Second
+}
+
 // CreateClient creates a new internal listener for clients.
 // Whether public listener is unique depends on request parameter.
 func (h *Handler) CreateClient(req *api.CreateClientRequest, svr api.ControlService_CreateClientServer) error {
 	ctx := svr.Context()
 	log := ctxlogrus.Extract(ctx)
+	if h.isDraining() {
+		return status.Errorf(codes.Unavailable, "server is shutting down")
+	}
+	owner, _ := auth.FromContext(ctx)
+	if req.SessionId != "" {
+		if ic, ok := h.sessions.Load(req.SessionId); ok {
+			return h.resume(svr, ic.(*Client), owner)
+		}
+		log.Infof("session %s is expired, create a new client", req.SessionId)
+	}
+	if req.Protocol == "" {
+		req.Protocol = "tcp"
+	}
+	if req.Protocol != "tcp" && req.Protocol != "udp" {
+		return status.Errorf(codes.InvalidArgument, "protocol %s is not supported", req.Protocol)
+	}
+	acl, err := accessListOfRequest(req.AccessList)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid access list: %v", err)
+	}
+	if !ValidPolicy(req.LbPolicy) {
+		return status.Errorf(codes.InvalidArgument, "lb policy %s is not supported", req.LbPolicy)
+	}
 	uid := strings.Replace(uuid.NewV1().String(), "-", "", -1)
-	c, err := NewClient(uid, req.DisplayName, h.host, fmt.Sprintf("%d", req.PublicPort), fmt.Sprintf("%d", req.InternalPort), req.SharePublicAddr, log)
+	cOpt := ClientOptions{
+		Name:        uid,
+		DisplayName: req.DisplayName,
+		Host:        h.host,
+		IntPort:     fmt.Sprintf("%d", req.InternalPort),
+		SharePub:    req.SharePublicAddr,
+		Multiplex:   req.Multiplex,
+		TLSConfig:   h.dataTLS,
+		Protocol:    req.Protocol,
+
+		UDPIdleTimeout: h.udpIdleTimeout,
+		quotas:         h.quotas,
+		connLimiter:    h.connLimiter,
+		AccessList:     acl,
+
+		serverAccessList:    h.accessLists[req.DisplayName],
+		AcceptProxyProtocol: h.acceptProxy,
+		LBPolicy:            req.LbPolicy,
+		Weight:              int64(req.Weight),
+		PairTimeout:         h.pairTimeout,
+
+		IdleTimeout:        secondsOr(req.IdleTimeout, h.idleTimeout),
+		MaxSessionDuration: secondsOr(req.MaxSessionDuration, h.maxSession),
+		KeepAlive:          secondsOr(req.KeepaliveInterval, h.keepAlive),
+	}
+	cOpt.RateLimit = rateLimitOfRequest(req)
+	if rl, ok := h.rateLimits[req.DisplayName]; ok {
+		cOpt.RateLimit = cOpt.RateLimit.override(rl)
+	}
+	cOpt.MaxUsers = int64(req.MaxUsers)
+	if h.maxUsersPerClient > 0 && (cOpt.MaxUsers == 0 || cOpt.MaxUsers > h.maxUsersPerClient) {
+		cOpt.MaxUsers = h.maxUsersPerClient
+	}
+	if h.intPort != "" {
+		cOpt.IntPort = h.intPort
+		cOpt.ShareInt = true
+	}
+	if req.PublicPort != 0 && !req.SharePublicAddr {
+		h.expireDetached(owner, req.PublicPort)
+	}
+	c, err := h.newClient(cOpt, req.PublicPort, log)
 	if err != nil {
 		return err
 	}
+	c.owner = owner
 
 	pr, ok := peer.FromContext(ctx)
 	if !ok {
@@ -59,6 +252,8 @@ func (h *Handler) CreateClient(req *api.CreateClientRequest, svr api.ControlServ
 		addr := net.JoinHostPort(host, fmt.Sprintf("%d", req.BackendPort))
 		stunConn, err := net.DialTimeout("tcp", addr, time.Second*5)
 		if err != nil {
+			metrics.DialFailures.WithLabelValues(req.DisplayName, "stun").Inc()
+			metrics.STUN.WithLabelValues("fallback").Inc()
 			return false
 		}
 		stunConn.Close()
@@ -73,44 +268,142 @@ func (h *Handler) CreateClient(req *api.CreateClientRequest, svr api.ControlServ
 		}
 		c.SetSTUNInfo(host, fmt.Sprintf("%d", req.BackendPort), req.Protocol)
 		h.clients.Store(uid, c)
+		metrics.STUN.WithLabelValues("success").Inc()
 		return true
 	}
-	if !stun() {
-		h.clients.Store(uid, c)
-		c.Start()
-		resp := &api.Client{
-			Name:            "",
-			InternalAddress: "",
-			DisplayName:     "",
-			PublicAddress:   c.PubAddr(),
-			SharePublicAddr: req.SharePublicAddr,
-		}
-		if err := svr.Send(resp); err != nil {
-			return err
-		}
+	if stun() {
+		stream, _ := c.attach()
+		return h.serve(svr, c, stream)
 	}
+	c.sessionID = newSecret()
+	h.clients.Store(uid, c)
+	h.sessions.Store(c.sessionID, c)
+	c.Start()
+	stream, _ := c.attach()
+	resp := &api.Client{
+		Name:            uid,
+		InternalAddress: "",
+		DisplayName:     "",
+		PublicAddress:   c.PubAddr(),
+		SharePublicAddr: req.SharePublicAddr,
+		SessionId:       c.sessionID,
+	}
+	if err := svr.Send(resp); err != nil {
+		h.closeClient(c)
+		return err
+	}
+	return h.serve(svr, c, stream)
+}
 
+// resume attaches the stream to the client kept after its stream broke.
+func (h *Handler) resume(svr api.ControlService_CreateClientServer, c *Client, owner *auth.Key) error {
+	if owner != c.owner {
+		return status.Errorf(codes.PermissionDenied, "%s is owned by others", c.name)
+	}
+	stream, ok := c.attach()
+	if !ok {
+		return status.Errorf(codes.NotFound, "session of %s is expired", c.name)
+	}
+	c.log().Infof("client resumed")
+	resp := &api.Client{
+		Name:            c.name,
+		PublicAddress:   c.PubAddr(),
+		SharePublicAddr: c.sharePub,
+		SessionId:       c.sessionID,
+	}
+	if err := svr.Send(resp); err != nil {
+		h.detach(c, stream)
+		return err
+	}
+	return h.serve(svr, c, stream)
+}
+
+// serve sends backend service users of c to l4proxy client until the stream breaks,
+// is taken over by another stream, or c is drained.
+func (h *Handler) serve(svr api.ControlService_CreateClientServer, c *Client, stream chan struct{}) error {
+	ctx := svr.Context()
+	if h.isDraining() {
+		// created while Drain ranges clients.
+		c.Drain(h.drainTimeout)
+	}
+	draining := c.draining
 	for {
 		select {
-		case token := <-c.NewPubConnNotifyCH:
+		case <-draining:
+			draining = nil
 			resp := &api.Client{
-				Name:            uid,
-				Token:           token.String(),
+				Name:          c.name,
+				PublicAddress: c.PubAddr(),
+				Draining:      true,
+			}
+			if err := svr.Send(resp); err != nil {
+				h.detach(c, stream)
+				return err
+			}
+		case <-c.Drained():
+			h.closeClient(c)
+			if h.isDraining() {
+				return status.Errorf(codes.Unavailable, "server is shutting down")
+			}
+			return status.Errorf(codes.FailedPrecondition, "client %s is drained", c.name)
+		case n := <-c.NewPubConnNotifyCH:
+			resp := &api.Client{
+				Name:            c.name,
+				Token:           n.Token.String(),
+				Secret:          n.Secret,
 				InternalAddress: c.IntAddr(),
+				InternalTls:     c.IntTLS(),
 				PublicAddress:   c.PubAddr(),
-				DisplayName:     req.DisplayName,
+				DisplayName:     c.displayName,
+				UserAddress:     n.UserAddr,
 			}
 			if err := svr.Send(resp); err != nil {
+				h.detach(c, stream)
 				return err
 			}
+		case <-stream:
+			return status.Errorf(codes.Aborted, "client %s is resumed by another stream", c.name)
 		case <-ctx.Done():
-			c.Close()
-			h.clients.Delete(c.name)
+			h.detach(c, stream)
 			return nil
 		}
 	}
 }
 
+// detach keeps c for session grace after its stream breaks, c is closed
+// if it is not resumable.
+func (h *Handler) detach(c *Client, stream chan struct{}) {
+	if c.sessionID == "" || h.sessionGrace <= 0 || h.isDraining() {
+		h.closeClient(c)
+		return
+	}
+	c.detach(stream, h.sessionGrace, func() {
+		c.log().Infof("client is not resumed in %s", h.sessionGrace)
+		h.closeClient(c)
+	})
+}
+
+// expireDetached closes detached clients of owner on the public port,
+// so that the port can be used by a new client of the owner.
+func (h *Handler) expireDetached(owner *auth.Key, pubPort int32) {
+	port := fmt.Sprintf("%d", pubPort)
+	h.clients.Range(func(k, v interface{}) bool {
+		c := v.(*Client)
+		if c.owner == owner && c.pubPort == port && !c.sharePub {
+			c.expire()
+		}
+		return true
+	})
+}
+
+func (h *Handler) closeClient(c *Client) {
+	c.Close()
+	h.clients.Delete(c.name)
+	if c.sessionID != "" {
+		h.sessions.Delete(c.sessionID)
+	}
+}
+
 func (h *Handler) ListClients(ctx context.Context, req *api.ListClientsRequest) (*api.ListClientsResponse, error) {
 	cs := []*api.Client{}
 	var count int32 = 0
@@ -123,6 +416,12 @@ func (h *Handler) ListClients(ctx context.Context, req *api.ListClientsRequest)
 			InternalAddress: c.IntAddr(),
 			PublicAddress:   c.PubAddr(),
 			SharePublicAddr: c.sharePub,
+			BytesIn:         uint64(c.BytesIn()),
+			BytesOut:        uint64(c.BytesOut()),
+			RejectedUsers:   uint64(c.Rejected()),
+			Health:          c.Health(),
+			UnclaimedUsers:  uint64(c.Unclaimed()),
+			Draining:        c.Draining(),
 		}
 		cs = append(cs, cc)
 		return true
@@ -144,8 +443,10 @@ func (h *Handler) ListBackendServiceUsers(ctx context.Context, req *api.ListBack
 		p := v.(*PairedConn)
 		u := &api.BackendServiceUser{
 			UserAddr: p.SRC.RemoteAddr().String(),
-			SpeedIn:  p.SpeedIn,
-			SpeedOut: p.SpeedOut,
+			SpeedIn:  p.SpeedIn(),
+			SpeedOut: p.SpeedOut(),
+			BytesIn:  uint64(p.BytesIn()),
+			BytesOut: uint64(p.BytesOut()),
 		}
 		us = append(us, u)
 		count += 1
@@ -157,6 +458,103 @@ func (h *Handler) ListBackendServiceUsers(ctx context.Context, req *api.ListBack
 	}, nil
 }
 
+// ownedClient returns the client of name if the caller is admin or owner of it.
+func (h *Handler) ownedClient(ctx context.Context, name string) (*Client, error) {
+	iClient, ok := h.clients.Load(name)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "%s does not found", name)
+	}
+	c := iClient.(*Client)
+	if k, ok := auth.FromContext(ctx); ok && !k.Admin && k != c.owner {
+		return nil, status.Errorf(codes.PermissionDenied, "%s is owned by others", name)
+	}
+	return c, nil
+}
+
+// UpdateAccessList replaces access list of a running client,
+// only admin or owner of the client is allowed.
+func (h *Handler) UpdateAccessList(ctx context.Context, req *api.UpdateAccessListRequest) (*api.AccessList, error) {
+	c, err := h.ownedClient(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	acl, err := accessListOfRequest(req.AccessList)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid access list: %v", err)
+	}
+	c.SetAccessList(acl)
+	ctxlogrus.Extract(ctx).Infof("access list of %s updated", req.Name)
+	return acl.toAPI(), nil
+}
+
+// ReportDialFailure retries the backend service user on another client or closes it,
+// token and secret sent to the client are required.
+func (h *Handler) ReportDialFailure(ctx context.Context, req *api.ReportDialFailureRequest) (*api.ReportDialFailureResponse, error) {
+	iClient, ok := h.clients.Load(req.Name)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "%s does not found", req.Name)
+	}
+	c := iClient.(*Client)
+	ctxlogrus.Extract(ctx).Warnf("client %s failed to serve user %s: %s", req.Name, req.Token, req.Error)
+	retried, err := c.Failover(req.Token, req.Secret)
+	if err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "%v", err)
+	}
+	return &api.ReportDialFailureResponse{Retried: retried}, nil
+}
+
+// ReportHealth updates health of the backend of a client,
+// only admin or owner of the client is allowed.
+func (h *Handler) ReportHealth(ctx context.Context, req *api.ReportHealthRequest) (*api.Health, error) {
+	c, err := h.ownedClient(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	c.SetHealth(req.Health)
+	return req.Health, nil
+}
+
+// DrainClient drains a client, only admin or owner of the client is allowed.
+func (h *Handler) DrainClient(ctx context.Context, req *api.DrainClientRequest) (*api.DrainClientResponse, error) {
+	c, err := h.ownedClient(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	c.Drain(secondsOr(req.DrainTimeout, h.drainTimeout))
+	ctxlogrus.Extract(ctx).Infof("client %s is draining", req.Name)
+	return &api.DrainClientResponse{Users: c.Users()}, nil
+}
+
+// Tunnel serves the multiplexed session of a client created with multiplex.
+func (h *Handler) Tunnel(svr api.ControlService_TunnelServer) error {
+	ctx := svr.Context()
+	md, _ := metadata.FromIncomingContext(ctx)
+	names := md.Get(tunnel.ClientMetadataKey)
+	if len(names) == 0 {
+		return status.Errorf(codes.InvalidArgument, "%s is required", tunnel.ClientMetadataKey)
+	}
+	iClient, ok := h.clients.Load(names[0])
+	if !ok {
+		return status.Errorf(codes.NotFound, "%s does not found", names[0])
+	}
+	c := iClient.(*Client)
+	if !c.Multiplex() {
+		return status.Errorf(codes.FailedPrecondition, "%s is not multiplexed", names[0])
+	}
+	if k, _ := auth.FromContext(ctx); k != c.owner {
+		return status.Errorf(codes.PermissionDenied, "%s is owned by others", names[0])
+	}
+	session, err := tunnel.Server(svr, nil)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		session.Close()
+	}()
+	return c.ServeTunnel(session)
+}
+
 type InternalService struct {
 	PublicPort  int32
 	Name        string
@@ -227,10 +625,31 @@ func (h *Handler) ListInternalService(ctx context.Context, req *api.ListInternal
 
 }
 
+// Drain refuses new clients and drains all clients,
+// it returns when all clients are drained.
+func (h *Handler) Drain() {
+	atomic.StoreInt32(&h.draining, 1)
+	h.clients.Range(func(k, v interface{}) bool {
+		v.(*Client).Drain(h.drainTimeout)
+		return true
+	})
+	h.clients.Range(func(k, v interface{}) bool {
+		<-v.(*Client).Drained()
+		return true
+	})
+	log.Info("all clients drained")
+	h.quotas.close()
+}
+
+func (h *Handler) isDraining() bool {
+	return atomic.LoadInt32(&h.draining) == 1
+}
+
 func (h *Handler) Close() {
 	h.services.Range(func(k, v interface{}) bool {
 		v.(*InternalService).Close()
 		return true
 	})
+	h.quotas.close()
 	log.Info("handler closed")
 }
@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+// busyPorts returns n consecutive free ports with all but the last one
+// occupied by the returned listeners.
+func busyPorts(t *testing.T, n int) (int32, []net.Listener) {
+	for try := 0; try < 100; try++ {
+		base := 20000 + rand.Intn(30000)
+		var ltns []net.Listener
+		for i := 0; i < n; i++ {
+			l, err := net.Listen("tcp", fmt.Sprintf(":%d", base+i))
+			if err != nil {
+				break
+			}
+			ltns = append(ltns, l)
+		}
+		if len(ltns) == n {
+			ltns[n-1].Close()
+			return int32(base), ltns[:n-1]
+		}
+		for _, l := range ltns {
+			l.Close()
+		}
+	}
+	t.Fatal("no free port range")
+	return 0, nil
+}
+
+func freePort(t *testing.T) string {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	return port
+}
+
+// TestNewClientBusyPubPort creates clients in a public port range whose ports
+// are busy except one, data connections on the shared internal port must
+// reach the client after failed attempts.
+func TestNewClientBusyPubPort(t *testing.T) {
+	min, ltns := busyPorts(t, 8)
+	defer func() {
+		for _, l := range ltns {
+			l.Close()
+		}
+	}()
+	intPort := freePort(t)
+	h := New(Options{
+		Host:       "127.0.0.1",
+		IntPort:    intPort,
+		PubPortMin: min,
+		PubPortMax: min + 7,
+	})
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("client%d", i)
+		c, err := h.newClient(ClientOptions{
+			Name:     name,
+			Host:     "127.0.0.1",
+			IntPort:  intPort,
+			ShareInt: true,
+		}, 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.pubPort != fmt.Sprintf("%d", min+7) {
+			t.Fatalf("public port is %s, want %d", c.pubPort, min+7)
+		}
+		// wait for failed attempts to release their routes.
+		time.Sleep(50 * time.Millisecond)
+		conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", intPort))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := writeHandshake(conn, &handshake{name: name}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case dc := <-c.intConnCH:
+			dc.Close()
+		case <-time.After(2 * time.Second):
+			t.Fatalf("data connection of %s is not delivered", name)
+		}
+		conn.Close()
+		c.Close()
+	}
+}
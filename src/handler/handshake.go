@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/winglq/l4proxy/src/api"
+)
+
+const handshakeTimeout = 10 * time.Second
+
+// handshake is the first message sent by l4proxy client on a data connection.
+// Each field is encoded as one byte length followed by the content.
+type handshake struct {
+	name   string
+	token  string
+	secret string
+}
+
+// dataConn is a connection from l4proxy client whose handshake has been read.
+type dataConn struct {
+	net.Conn
+	handshake
+}
+
+func writeField(w io.Writer, field string) error {
+	if len(field) > 255 {
+		return fmt.Errorf("handshake field %s is too long", field)
+	}
+	_, err := w.Write(append([]byte{byte(len(field))}, field...))
+	return err
+}
+
+func readField(r io.Reader) (string, error) {
+	l := make([]byte, 1)
+	if _, err := io.ReadFull(r, l); err != nil {
+		return "", err
+	}
+	buf := make([]byte, int(l[0]))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+	return string(buf), nil
+}
+
+func writeHandshake(w io.Writer, hs *handshake) error {
+	for _, f := range []string{hs.name, hs.token, hs.secret} {
+		if err := writeField(w, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readHandshake(conn net.Conn) (*dataConn, error) {
+	conn.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	fields := make([]string, 3)
+	for i := range fields {
+		f, err := readField(conn)
+		if err != nil {
+			return nil, err
+		}
+		fields[i] = f
+	}
+	return &dataConn{
+		Conn: conn,
+		handshake: handshake{
+			name:   fields[0],
+			token:  fields[1],
+			secret: fields[2],
+		},
+	}, nil
+}
+
+func newSecret() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// DialInternal connects to the internal address in resp and sends the handshake.
+// tlsConfig is used only if the internal address speaks tls.
+func DialInternal(resp *api.Client, tlsConfig *tls.Config) (net.Conn, error) {
+	c, err := net.Dial("tcp", resp.InternalAddress)
+	if err != nil {
+		return nil, err
+	}
+	if resp.InternalTls {
+		cfg := tlsConfig.Clone()
+		if cfg.ServerName == "" {
+			cfg.ServerName, _, _ = net.SplitHostPort(resp.InternalAddress)
+		}
+		c = tls.Client(c, cfg)
+	}
+	if err := Handshake(c, resp); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Handshake sends the handshake for the backend service user in resp.
+func Handshake(c net.Conn, resp *api.Client) error {
+	return writeHandshake(c, &handshake{
+		name:   resp.Name,
+		token:  resp.Token,
+		secret: resp.Secret,
+	})
+}
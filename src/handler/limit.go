@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// reasons of rejected backend service users.
+const (
+	rejectAccessList = "access_list"
+	rejectDetached   = "detached"
+	rejectDraining   = "draining"
+	rejectIPRate     = "ip_rate"
+	rejectMaxConns   = "max_conns"
+	rejectMaxUsers   = "max_users"
+	rejectQuota      = "quota"
+	// users of shared public port are rejected only if no client is healthy.
+	rejectUnhealthy = "unhealthy"
+)
+
+// ipLimiterTTL is how long an idle source ip is remembered.
+const ipLimiterTTL = 10 * time.Minute
+
+type ipLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// connLimiter limits backend service users of the whole server.
+type connLimiter struct {
+	// conns is accessed atomically, keep it first to be 64-bit aligned.
+	conns     int64
+	maxConns  int64
+	ipRate    rate.Limit
+	ipBurst   int
+	ips       map[string]*ipLimiter
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+// newConnLimiter returns a limiter allows at most maxConns concurrent users and
+// ipRate new connections per second from a source ip, zero means unlimited.
+func newConnLimiter(maxConns int64, ipRate float64) *connLimiter {
+	burst := int(ipRate)
+	if burst < 1 {
+		burst = 1
+	}
+	return &connLimiter{
+		maxConns:  maxConns,
+		ipRate:    rate.Limit(ipRate),
+		ipBurst:   burst,
+		ips:       map[string]*ipLimiter{},
+		lastSweep: time.Now(),
+	}
+}
+
+// allowIP reports whether a new connection from addr is allowed.
+func (l *connLimiter) allowIP(addr net.Addr) bool {
+	if l == nil || l.ipRate == 0 {
+		return true
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if now.Sub(l.lastSweep) > ipLimiterTTL {
+		for ip, il := range l.ips {
+			if now.Sub(il.lastSeen) > ipLimiterTTL {
+				delete(l.ips, ip)
+			}
+		}
+		l.lastSweep = now
+	}
+	il, ok := l.ips[host]
+	if !ok {
+		il = &ipLimiter{limiter: rate.NewLimiter(l.ipRate, l.ipBurst)}
+		l.ips[host] = il
+	}
+	il.lastSeen = now
+	return il.limiter.AllowN(now, 1)
+}
+
+// acquire reserves a connection, release must be called if it returns true.
+func (l *connLimiter) acquire() bool {
+	if l == nil {
+		return true
+	}
+	if n := atomic.AddInt64(&l.conns, 1); l.maxConns > 0 && n > l.maxConns {
+		atomic.AddInt64(&l.conns, -1)
+		return false
+	}
+	return true
+}
+
+func (l *connLimiter) release() {
+	if l == nil {
+		return
+	}
+	atomic.AddInt64(&l.conns, -1)
+}
@@ -1,9 +1,13 @@
 package handler
 
 import (
+	"crypto/tls"
 	"net"
+	"strings"
 	"sync"
 	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
 )
 
 // SharedListener uses reference count to ensure
@@ -73,3 +77,85 @@ func Listen(network, address string, onClose func()) (net.Listener, error) {
 	ls.Store(k, sl)
 	return sl, nil
 }
+
+var dms = sync.Map{}
+
+type route struct {
+	ch   chan *dataConn
+	done chan struct{}
+}
+
+// demux dispatches data connections accepted on a shared internal
+// listener to clients by the client name in handshake.
+// The listener is a SharedListener referenced by each registered client,
+// it is closed when the last client is closed.
+type demux struct {
+	ltn       net.Listener
+	tlsConfig *tls.Config
+	routes    sync.Map
+	// mu serializes register and unregister of the same name.
+	mu sync.Mutex
+}
+
+func newDemux(ltn net.Listener, tlsConfig *tls.Config) *demux {
+	return &demux{
+		ltn:       ltn,
+		tlsConfig: tlsConfig,
+	}
+}
+
+func (d *demux) register(name string, ch chan *dataConn, done chan struct{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.routes.Store(name, &route{
+		ch:   ch,
+		done: done,
+	})
+}
+
+// unregister deletes the route of name only if it is registered with done,
+// a failed attempt of a client must not delete the route of a later attempt
+// with the same name.
+func (d *demux) unregister(name string, done chan struct{}) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if ir, ok := d.routes.Load(name); ok && ir.(*route).done == done {
+		d.routes.Delete(name)
+	}
+}
+
+func (d *demux) serve() {
+	for {
+		conn, err := d.ltn.Accept()
+		if err != nil && strings.Contains(err.Error(), "use of closed network connection") {
+			return
+		} else if err != nil {
+			panic(err)
+		}
+		if d.tlsConfig != nil {
+			conn = tls.Server(conn, d.tlsConfig)
+		}
+		go d.dispatch(conn)
+	}
+}
+
+func (d *demux) dispatch(conn net.Conn) {
+	dc, err := readHandshake(conn)
+	if err != nil {
+		log.Warnf("read handshake from %s failed: %v", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	ir, ok := d.routes.Load(dc.name)
+	if !ok {
+		log.Warnf("client %s of data connection %s does not exist", dc.name, conn.RemoteAddr())
+		conn.Close()
+		return
+	}
+	r := ir.(*route)
+	select {
+	case r.ch <- dc:
+	case <-r.done:
+		conn.Close()
+	}
+}
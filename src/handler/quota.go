@@ -0,0 +1,243 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/inhies/go-bytesize"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+)
+
+// quotaFlushInterval is the interval bytes are folded into usage
+// and usage is written to the state file.
+const quotaFlushInterval = time.Minute
+
+// Quota limits bytes(in and out) of all clients with the same display name,
+// zero means unlimited.
+type Quota struct {
+	Daily   uint64
+	Monthly uint64
+}
+
+// ParseQuotas parses quotas from config, sizes are strings like 10GB.
+//
+//	ssh:
+//	  daily: 1GB
+//	  monthly: 10GB
+func ParseQuotas(raw map[string]interface{}) (map[string]Quota, error) {
+	qs := map[string]Quota{}
+	for name, v := range raw {
+		m, err := cast.ToStringMapE(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid quota of %s: %v", name, err)
+		}
+		q := Quota{}
+		for k, size := range m {
+			b, err := bytesize.Parse(fmt.Sprintf("%v", size))
+			if err != nil {
+				return nil, fmt.Errorf("invalid quota of %s: %v", name, err)
+			}
+			switch k {
+			case "daily":
+				q.Daily = uint64(b)
+			case "monthly":
+				q.Monthly = uint64(b)
+			default:
+				return nil, fmt.Errorf("unknown quota %s of %s", k, name)
+			}
+		}
+		qs[name] = q
+	}
+	return qs, nil
+}
+
+type quotaUsage struct {
+	Day     string `json:"day"`
+	Month   string `json:"month"`
+	Daily   uint64 `json:"daily"`
+	Monthly uint64 `json:"monthly"`
+}
+
+// quotas tracks usage of display names which have quota, usage is
+// kept in memory and written to stateFile periodically and on close
+// if stateFile is not empty.
+type quotas struct {
+	limits map[string]Quota
+	// pending are bytes by display name not folded into usages yet,
+	// the map is not changed after newQuotas and counters are accessed atomically.
+	pending   map[string]*uint64
+	usages    map[string]*quotaUsage
+	stateFile string
+	stop      chan struct{}
+	stopOnce  sync.Once
+	mu        sync.Mutex
+}
+
+func newQuotas(limits map[string]Quota, stateFile string) *quotas {
+	q := &quotas{
+		limits:    limits,
+		pending:   map[string]*uint64{},
+		usages:    map[string]*quotaUsage{},
+		stateFile: stateFile,
+		stop:      make(chan struct{}),
+	}
+	for name := range limits {
+		q.pending[name] = new(uint64)
+	}
+	if stateFile != "" {
+		if err := q.load(); err != nil {
+			log.Warnf("failed to load quota usage from %s: %v", stateFile, err)
+		}
+	}
+	if len(limits) > 0 || stateFile != "" {
+		go q.run()
+	}
+	return q
+}
+
+func (q *quotas) load() error {
+	b, err := ioutil.ReadFile(q.stateFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	usages := map[string]*quotaUsage{}
+	if err := json.Unmarshal(b, &usages); err != nil {
+		return err
+	}
+	q.usages = usages
+	return nil
+}
+
+// save writes usage to a temporary file and renames it to the state file,
+// so the state file is never left half written.
+func (q *quotas) save() error {
+	q.mu.Lock()
+	q.fold()
+	b, err := json.Marshal(q.usages)
+	q.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(q.stateFile), filepath.Base(q.stateFile))
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(b); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), q.stateFile)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (q *quotas) run() {
+	ticker := time.NewTicker(quotaFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if q.stateFile == "" {
+				q.mu.Lock()
+				q.fold()
+				q.mu.Unlock()
+				continue
+			}
+			if err := q.save(); err != nil {
+				log.Warnf("failed to save quota usage to %s: %v", q.stateFile, err)
+			}
+		case <-q.stop:
+			return
+		}
+	}
+}
+
+// close stops periodic flushing and writes usage to the state file for the last time.
+func (q *quotas) close() {
+	q.stopOnce.Do(func() {
+		close(q.stop)
+		if q.stateFile == "" {
+			return
+		}
+		if err := q.save(); err != nil {
+			log.Warnf("failed to save quota usage to %s: %v", q.stateFile, err)
+			return
+		}
+		log.Infof("quota usage saved to %s", q.stateFile)
+	})
+}
+
+// usage returns usage of the current day and month, must be called with lock held.
+func (q *quotas) usage(displayName string) *quotaUsage {
+	now := time.Now()
+	day, month := now.Format("2006-01-02"), now.Format("2006-01")
+	u, ok := q.usages[displayName]
+	if !ok {
+		u = &quotaUsage{Day: day, Month: month}
+		q.usages[displayName] = u
+	}
+	if u.Day != day {
+		u.Day = day
+		u.Daily = 0
+	}
+	if u.Month != month {
+		u.Month = month
+		u.Monthly = 0
+	}
+	return u
+}
+
+// fold moves pending bytes into usage of the current day and month,
+// must be called with lock held.
+func (q *quotas) fold() {
+	for name, p := range q.pending {
+		n := atomic.SwapUint64(p, 0)
+		if n == 0 {
+			continue
+		}
+		u := q.usage(name)
+		u.Daily += n
+		u.Monthly += n
+	}
+}
+
+// add is called on the data path, it only adds n to the pending counter.
+func (q *quotas) add(displayName string, n int) {
+	if q == nil {
+		return
+	}
+	if p, ok := q.pending[displayName]; ok {
+		atomic.AddUint64(p, uint64(n))
+	}
+}
+
+func (q *quotas) exceeded(displayName string) bool {
+	if q == nil {
+		return false
+	}
+	l, ok := q.limits[displayName]
+	if !ok {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	u := q.usage(displayName)
+	p := atomic.LoadUint64(q.pending[displayName])
+	return (l.Daily != 0 && u.Daily+p >= l.Daily) || (l.Monthly != 0 && u.Monthly+p >= l.Monthly)
+}
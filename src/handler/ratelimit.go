@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/inhies/go-bytesize"
+	"github.com/spf13/cast"
+	"github.com/winglq/l4proxy/src/api"
+	"golang.org/x/time/rate"
+)
+
+// RateLimit is bandwidth limits in bytes per second, zero means unlimited.
+// In is from backend service users to backend, Out is the reverse.
+// In and Out are shared by all users of a client, UserIn and UserOut
+// are for each user.
+type RateLimit struct {
+	In      uint64
+	Out     uint64
+	UserIn  uint64
+	UserOut uint64
+}
+
+// ParseRateLimits parses rate limits from config, rates are sizes per second like 1MB.
+//
+//	nas:
+//	  out: 10MB
+//	  user_out: 2MB
+func ParseRateLimits(raw map[string]interface{}) (map[string]RateLimit, error) {
+	rls := map[string]RateLimit{}
+	for name, v := range raw {
+		m, err := cast.ToStringMapE(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate limit of %s: %v", name, err)
+		}
+		rl := RateLimit{}
+		for k, size := range m {
+			b, err := bytesize.Parse(fmt.Sprintf("%v", size))
+			if err != nil {
+				return nil, fmt.Errorf("invalid rate limit of %s: %v", name, err)
+			}
+			switch k {
+			case "in":
+				rl.In = uint64(b)
+			case "out":
+				rl.Out = uint64(b)
+			case "user_in":
+				rl.UserIn = uint64(b)
+			case "user_out":
+				rl.UserOut = uint64(b)
+			default:
+				return nil, fmt.Errorf("unknown rate limit %s of %s", k, name)
+			}
+		}
+		rls[name] = rl
+	}
+	return rls, nil
+}
+
+func rateLimitOfRequest(req *api.CreateClientRequest) RateLimit {
+	return RateLimit{
+		In:      req.RateLimitIn,
+		Out:     req.RateLimitOut,
+		UserIn:  req.UserRateLimitIn,
+		UserOut: req.UserRateLimitOut,
+	}
+}
+
+// override returns rl with limits set in o replaced.
+func (rl RateLimit) override(o RateLimit) RateLimit {
+	if o.In != 0 {
+		rl.In = o.In
+	}
+	if o.Out != 0 {
+		rl.Out = o.Out
+	}
+	if o.UserIn != 0 {
+		rl.UserIn = o.UserIn
+	}
+	if o.UserOut != 0 {
+		rl.UserOut = o.UserOut
+	}
+	return rl
+}
+
+// newLimiter returns nil if bps is zero, burst is one second of bytes.
+func newLimiter(bps uint64) *rate.Limiter {
+	if bps == 0 {
+		return nil
+	}
+	burst := bps
+	if burst > math.MaxInt32 {
+		burst = math.MaxInt32
+	}
+	return rate.NewLimiter(rate.Limit(bps), int(burst))
+}
+
+// limiters returns the non nil limiters of ls.
+func limiters(ls ...*rate.Limiter) []*rate.Limiter {
+	ret := []*rate.Limiter{}
+	for _, l := range ls {
+		if l != nil {
+			ret = append(ret, l)
+		}
+	}
+	return ret
+}
+
+// waitN blocks until n bytes are allowed by all limiters,
+// n is split by burst of each limiter.
+func waitN(ctx context.Context, limiters []*rate.Limiter, n int) error {
+	for _, l := range limiters {
+		for left := n; left > 0; {
+			k := left
+			if k > l.Burst() {
+				k = l.Burst()
+			}
+			if err := l.WaitN(ctx, k); err != nil {
+				return err
+			}
+			left -= k
+		}
+	}
+	return nil
+}
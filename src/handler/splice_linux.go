@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"net"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/time/rate"
+)
+
+// maxSpliceSize is the most bytes moved by one splice(2), the default capacity of a pipe.
+const maxSpliceSize = 64 * 1024
+
+// spliceCopy copies src to dst by splice(2) through a pipe, bytes are accounted and
+// limited after each splice, so that counters do not lag behind the data.
+// handled is false if splice can not be used, nothing is copied then.
+func spliceCopy(ctx context.Context, src, dst *net.TCPConn, limiters []*rate.Limiter, account func(n int)) (written int64, handled bool, err error) {
+	var p [2]int
+	if err := unix.Pipe2(p[:], unix.O_CLOEXEC|unix.O_NONBLOCK); err != nil {
+		return 0, false, nil
+	}
+	defer unix.Close(p[0])
+	defer unix.Close(p[1])
+	rc, err := src.SyscallConn()
+	if err != nil {
+		return 0, false, nil
+	}
+	wc, err := dst.SyscallConn()
+	if err != nil {
+		return 0, false, nil
+	}
+	// a rate limited pair splices at most a burst at a time, so that it
+	// never waits for more tokens than the limiter holds.
+	size := maxSpliceSize
+	for _, l := range limiters {
+		if b := l.Burst(); b > 0 && b < size {
+			size = b
+		}
+	}
+	for {
+		// src to pipe, waits until src is readable.
+		var n int64
+		var serr error
+		err = rc.Read(func(fd uintptr) bool {
+			n, serr = unix.Splice(int(fd), nil, p[1], nil, size, unix.SPLICE_F_MOVE|unix.SPLICE_F_NONBLOCK)
+			return serr != unix.EAGAIN
+		})
+		if err == nil {
+			err = serr
+		}
+		if err == unix.EINVAL && written == 0 {
+			// splice is not supported by the socket.
+			return 0, false, nil
+		}
+		if err != nil || n == 0 {
+			// n is 0 at EOF of src.
+			return written, true, err
+		}
+		if err = waitN(ctx, limiters, int(n)); err != nil {
+			return written, true, err
+		}
+		// pipe to dst, waits until dst is writable.
+		for n > 0 {
+			var m int64
+			err = wc.Write(func(fd uintptr) bool {
+				m, serr = unix.Splice(p[0], nil, int(fd), nil, int(n), unix.SPLICE_F_MOVE|unix.SPLICE_F_NONBLOCK)
+				return serr != unix.EAGAIN
+			})
+			if err == nil {
+				err = serr
+			}
+			if m > 0 {
+				n -= m
+				written += m
+				account(int(m))
+			}
+			if err != nil {
+				return written, true, err
+			}
+			if m == 0 {
+				// bytes left in the pipe can not be moved to dst.
+				return written, true, io.ErrUnexpectedEOF
+			}
+		}
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package handler
+
+import (
+	"context"
+	"net"
+
+	"golang.org/x/time/rate"
+)
+
+// spliceCopy is only supported on linux, the buffered copy is used elsewhere.
+func spliceCopy(ctx context.Context, src, dst *net.TCPConn, limiters []*rate.Limiter, account func(n int)) (written int64, handled bool, err error) {
+	return 0, false, nil
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// statsInterval is how often rates of all pairs are sampled.
+	statsInterval = time.Second
+	// rateWindow is the time constant of the moving average of rates.
+	rateWindow = 5 * time.Second
+)
+
+// alpha is the weight of the latest sample in the moving average.
+var alpha = 1 - math.Exp(-statsInterval.Seconds()/rateWindow.Seconds())
+
+// ewma is an exponentially weighted moving average of bytes per second.
+type ewma struct {
+	// bits of the float64 rate, accessed atomically.
+	bits uint64
+	// counter value at the last sample, only accessed by the sampler.
+	last int64
+}
+
+func (e *ewma) update(total int64) {
+	r := float64(total-e.last) / statsInterval.Seconds()
+	e.last = total
+	old := e.rate()
+	atomic.StoreUint64(&e.bits, math.Float64bits(old+alpha*(r-old)))
+}
+
+func (e *ewma) rate() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&e.bits))
+}
+
+// sampler updates rates of all copying pairs by a single ticker.
+type sampler struct {
+	pairs sync.Map
+	once  sync.Once
+}
+
+var stats sampler
+
+func (s *sampler) add(pc *PairedConn) {
+	s.once.Do(func() {
+		go s.run()
+	})
+	s.pairs.Store(pc, struct{}{})
+}
+
+func (s *sampler) remove(pc *PairedConn) {
+	s.pairs.Delete(pc)
+}
+
+func (s *sampler) run() {
+	t := time.NewTicker(statsInterval)
+	for range t.C {
+		s.pairs.Range(func(k, v interface{}) bool {
+			pc := k.(*PairedConn)
+			pc.rateIn.update(pc.BytesIn())
+			pc.rateOut.update(pc.BytesOut())
+			return true
+		})
+	}
+}
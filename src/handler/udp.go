@@ -0,0 +1,260 @@
+package handler
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const maxDatagramSize = 65535
+
+// udpBacklog is the number of new sessions waiting to be accepted,
+// datagrams from new source addresses are dropped while it is full.
+const udpBacklog = 128
+
+// packetConn is implemented by conns which keep message boundaries,
+// copy buffer must be large enough to hold a whole datagram.
+type packetConn interface {
+	net.Conn
+	packet()
+}
+
+var packetPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, maxDatagramSize)
+		return buf
+	},
+}
+
+// udpListener demultiplexes datagrams received on a packet conn to sessions
+// by source address, each session is accepted as a net.Conn.
+type udpListener struct {
+	pc          net.PacketConn
+	idleTimeout time.Duration
+	sessions    sync.Map
+	ch          chan net.Conn
+	done        chan struct{}
+	once        sync.Once
+	// stop is closed by stopAccept.
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func listenUDP(addr string, idleTimeout time.Duration) (*udpListener, error) {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	l := &udpListener{
+		pc:          pc,
+		idleTimeout: idleTimeout,
+		ch:          make(chan net.Conn, udpBacklog),
+		done:        make(chan struct{}),
+		stop:        make(chan struct{}),
+	}
+	go l.serve()
+	if idleTimeout > 0 {
+		go l.expire()
+	}
+	return l, nil
+}
+
+func (l *udpListener) serve() {
+	defer close(l.ch)
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := l.pc.ReadFrom(buf)
+		if err != nil && strings.Contains(err.Error(), "use of closed network connection") {
+			return
+		} else if err != nil {
+			continue
+		}
+		is, ok := l.sessions.Load(addr.String())
+		if !ok {
+			select {
+			case <-l.stop:
+				continue
+			default:
+			}
+			s := newUDPSession(l, addr)
+			l.sessions.Store(addr.String(), s)
+			// never blocks, so that existing sessions are not stalled by a slow accept.
+			select {
+			case l.ch <- s:
+			default:
+				l.sessions.Delete(addr.String())
+				continue
+			}
+			is = s
+		}
+		is.(*udpSession).deliver(append([]byte(nil), buf[:n]...))
+	}
+}
+
+// expire closes sessions which have no traffic in idleTimeout, it is not started
+// if idleTimeout is zero.
+func (l *udpListener) expire() {
+	t := time.NewTicker(l.idleTimeout / 2)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			l.sessions.Range(func(k, v interface{}) bool {
+				s := v.(*udpSession)
+				if time.Since(time.Unix(0, atomic.LoadInt64(&s.lastActive))) > l.idleTimeout {
+					s.Close()
+				}
+				return true
+			})
+		case <-l.done:
+			return
+		}
+	}
+}
+
+func (l *udpListener) Addr() net.Addr {
+	return l.pc.LocalAddr()
+}
+
+// stopAccept stops accepting new source addresses, their datagrams are dropped
+// while existing sessions are still served.
+func (l *udpListener) stopAccept() {
+	l.stopOnce.Do(func() {
+		close(l.stop)
+	})
+}
+
+func (l *udpListener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+	})
+	l.sessions.Range(func(k, v interface{}) bool {
+		v.(*udpSession).Close()
+		return true
+	})
+	return l.pc.Close()
+}
+
+// udpSession is the datagrams from one source address.
+// Each Read returns one datagram, and each Write sends one datagram.
+type udpSession struct {
+	l          *udpListener
+	raddr      net.Addr
+	readCH     chan []byte
+	done       chan struct{}
+	once       sync.Once
+	lastActive int64
+}
+
+func newUDPSession(l *udpListener, raddr net.Addr) *udpSession {
+	return &udpSession{
+		l:          l,
+		raddr:      raddr,
+		readCH:     make(chan []byte, 64),
+		done:       make(chan struct{}),
+		lastActive: time.Now().UnixNano(),
+	}
+}
+
+func (s *udpSession) packet() {}
+
+// deliver drops the datagram if the session is too slow to read.
+func (s *udpSession) deliver(p []byte) {
+	atomic.StoreInt64(&s.lastActive, time.Now().UnixNano())
+	select {
+	case s.readCH <- p:
+	default:
+	}
+}
+
+func (s *udpSession) Read(p []byte) (int, error) {
+	select {
+	case data := <-s.readCH:
+		return copy(p, data), nil
+	case <-s.done:
+		return 0, io.EOF
+	}
+}
+
+func (s *udpSession) Write(p []byte) (int, error) {
+	select {
+	case <-s.done:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	atomic.StoreInt64(&s.lastActive, time.Now().UnixNano())
+	return s.l.pc.WriteTo(p, s.raddr)
+}
+
+func (s *udpSession) Close() error {
+	s.once.Do(func() {
+		close(s.done)
+		s.l.sessions.Delete(s.raddr.String())
+	})
+	return nil
+}
+
+func (s *udpSession) LocalAddr() net.Addr {
+	return s.l.pc.LocalAddr()
+}
+
+func (s *udpSession) RemoteAddr() net.Addr {
+	return s.raddr
+}
+
+func (s *udpSession) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (s *udpSession) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (s *udpSession) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// framedConn keeps datagram boundaries on a stream conn,
+// each datagram is prefixed by its length in two bytes.
+type framedConn struct {
+	net.Conn
+	wmu sync.Mutex
+}
+
+// NewFramedConn wraps a data connection which relays datagrams.
+func NewFramedConn(c net.Conn) net.Conn {
+	return &framedConn{Conn: c}
+}
+
+func (f *framedConn) packet() {}
+
+func (f *framedConn) Read(p []byte) (int, error) {
+	var l [2]byte
+	if _, err := io.ReadFull(f.Conn, l[:]); err != nil {
+		return 0, err
+	}
+	n := int(binary.BigEndian.Uint16(l[:]))
+	if n > len(p) {
+		return 0, io.ErrShortBuffer
+	}
+	return io.ReadFull(f.Conn, p[:n])
+}
+
+func (f *framedConn) Write(p []byte) (int, error) {
+	if len(p) > maxDatagramSize {
+		return 0, io.ErrShortWrite
+	}
+	buf := make([]byte, len(p)+2)
+	binary.BigEndian.PutUint16(buf, uint16(len(p)))
+	copy(buf[2:], p)
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+	if _, err := f.Conn.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -8,26 +8,56 @@ import (
 	"os/signal"
 	"strings"
 	"sync"
+	"time"
 
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_logrus "github.com/grpc-ecosystem/go-grpc-middleware/logging/logrus"
 	grpc_validator "github.com/grpc-ecosystem/go-grpc-middleware/validator"
+	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/winglq/l4proxy/src/api"
+	"github.com/winglq/l4proxy/src/auth"
+	"github.com/winglq/l4proxy/src/client"
 	"github.com/winglq/l4proxy/src/client/cmd"
 	"github.com/winglq/l4proxy/src/client/forwarder"
+	"github.com/winglq/l4proxy/src/config"
 	"github.com/winglq/l4proxy/src/handler"
+	"github.com/winglq/l4proxy/src/metrics"
+	"github.com/winglq/l4proxy/src/tlsutil"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 type ServerOptions struct {
-	ctlAddr string
-	host    string
+	ctlAddr      string
+	host         string
+	tlsCert      string
+	tlsKey       string
+	ca           string
+	authToken    string
+	authPolicy   string
+	dataTLS      bool
+	intPort      int32
+	udpIdle      time.Duration
+	portRange    string
+	configFile   string
+	quotaState   string
+	metricsAddr  string
+	maxUsers     int64
+	maxUsersPer  int64
+	ipConnRate   float64
+	acceptProxy  bool
+	pairTimeout  time.Duration
+	idleTimeout  time.Duration
+	maxSession   time.Duration
+	keepAlive    time.Duration
+	drainTimeout time.Duration
+	sessionGrace time.Duration
 }
 
 var opt ServerOptions
-var cSig = make(chan os.Signal)
+var cSig = make(chan os.Signal, 1)
 var done = make(chan struct{})
 
 func init() {
@@ -38,6 +68,9 @@ func init() {
 		close(done)
 		cmd.Close()
 		forwarder.Close()
+		<-cSig
+		log.Warn("interrupted again, exit immediately")
+		os.Exit(1)
 	}()
 }
 
@@ -65,29 +98,111 @@ func newServerCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use: "server",
 		Run: func(cmd *cobra.Command, args []string) {
+			var quotas map[string]handler.Quota
+			var rateLimits map[string]handler.RateLimit
+			var accessLists map[string]*handler.AccessList
+			if opt.configFile != "" {
+				v, err := config.Load(opt.configFile, cmd.Flags())
+				if err != nil {
+					log.Fatalf("failed to load config: %v", err)
+				}
+				quotas, err = handler.ParseQuotas(v.GetStringMap("quotas"))
+				if err != nil {
+					log.Fatalf("failed to load quotas: %v", err)
+				}
+				rateLimits, err = handler.ParseRateLimits(v.GetStringMap("rate_limits"))
+				if err != nil {
+					log.Fatalf("failed to load rate limits: %v", err)
+				}
+				accessLists, err = handler.ParseAccessLists(v.GetStringMap("access_lists"))
+				if err != nil {
+					log.Fatalf("failed to load access lists: %v", err)
+				}
+			}
 			ctlLtn, err := net.Listen("tcp", opt.ctlAddr)
 			if err != nil {
 				panic(err)
 			}
 			log.Printf("listen on ctl addr %s", ctlLtn.Addr())
+			tlsCfg, err := tlsutil.ServerConfig(opt.tlsCert, opt.tlsKey, opt.ca)
+			if err != nil {
+				log.Fatalf("failed to load tls config: %v", err)
+			}
 			entry := log.WithFields(log.Fields{})
 			grpc_logrus.ReplaceGrpcLogger(entry)
-			grpcServer := grpc.NewServer(
-				grpc_middleware.WithUnaryServerChain(
-					grpc_logrus.UnaryServerInterceptor(entry),
-					grpc_validator.UnaryServerInterceptor(),
-				),
-				grpc_middleware.WithStreamServerChain(
-					grpc_logrus.StreamServerInterceptor(entry),
-					grpc_validator.StreamServerInterceptor(),
-				))
+			svrOpts := []grpc.ServerOption{}
+			if tlsCfg != nil {
+				svrOpts = append(svrOpts, grpc.Creds(credentials.NewTLS(tlsCfg)))
+			}
+			unaryChain := []grpc.UnaryServerInterceptor{grpc_logrus.UnaryServerInterceptor(entry)}
+			streamChain := []grpc.StreamServerInterceptor{grpc_logrus.StreamServerInterceptor(entry)}
+			if opt.metricsAddr != "" {
+				unaryChain = append(unaryChain, grpc_prometheus.UnaryServerInterceptor)
+				streamChain = append(streamChain, grpc_prometheus.StreamServerInterceptor)
+			}
+			policy, err := loadAuthPolicy()
+			if err != nil {
+				log.Fatalf("failed to load auth policy: %v", err)
+			}
+			if policy != nil {
+				unaryChain = append(unaryChain, auth.UnaryServerInterceptor(policy))
+				streamChain = append(streamChain, auth.StreamServerInterceptor(policy))
+			} else {
+				log.Warn("authentication is disabled, anyone can create clients")
+			}
+			unaryChain = append(unaryChain, grpc_validator.UnaryServerInterceptor())
+			streamChain = append(streamChain, grpc_validator.StreamServerInterceptor())
+			grpcServer := grpc.NewServer(append(svrOpts,
+				grpc_middleware.WithUnaryServerChain(unaryChain...),
+				grpc_middleware.WithStreamServerChain(streamChain...))...)
+			hOpt := handler.Options{
+				Host:           opt.host,
+				UDPIdleTimeout: opt.udpIdle,
+				Quotas:         quotas,
+				QuotaStateFile: opt.quotaState,
+				RateLimits:     rateLimits,
+				AccessLists:    accessLists,
+
+				MaxUsers:          opt.maxUsers,
+				MaxUsersPerClient: opt.maxUsersPer,
+				NewConnsPerIP:     opt.ipConnRate,
+
+				AcceptProxyProtocol: opt.acceptProxy,
+				PairTimeout:         opt.pairTimeout,
+				IdleTimeout:         opt.idleTimeout,
+				MaxSessionDuration:  opt.maxSession,
+				KeepAlive:           opt.keepAlive,
+				DrainTimeout:        opt.drainTimeout,
+				SessionGrace:        opt.sessionGrace,
+			}
+			if opt.intPort != 0 {
+				hOpt.IntPort = fmt.Sprintf("%d", opt.intPort)
+			}
+			if opt.portRange != "" {
+				hOpt.PubPortMin, hOpt.PubPortMax, err = config.ParsePortRange(opt.portRange)
+				if err != nil {
+					log.Fatalf("%v", err)
+				}
+			}
+			if opt.dataTLS {
+				if tlsCfg == nil {
+					log.Fatalf("--data_tls requires --tls_cert and --tls_key")
+				}
+				hOpt.DataTLS = tlsCfg
+			}
+			h := handler.New(hOpt)
+			defer h.Close()
 			go func() {
 				<-done
-				grpcServer.Stop()
+				log.Infof("draining clients, interrupt again to exit immediately")
+				h.Drain()
+				grpcServer.GracefulStop()
 			}()
-			h := handler.New(opt.host)
-			defer h.Close()
 			api.RegisterControlServiceServer(grpcServer, h)
+			if opt.metricsAddr != "" {
+				grpc_prometheus.Register(grpcServer)
+				metrics.Serve(opt.metricsAddr)
+			}
 			err = grpcServer.Serve(ctlLtn)
 			if err != nil {
 				panic(err)
@@ -95,24 +210,65 @@ func newServerCmd() *cobra.Command {
 
 		},
 	}
+	cmd.Flags().StringVar(&opt.configFile, "config", "", "config file(yaml, json or toml), keys are named after flags, flags in command line take precedence")
 	cmd.Flags().StringVar(&opt.ctlAddr, "ctl_addr", ":2222", "server address")
 	cmd.Flags().StringVar(&opt.host, "host", "127.0.0.1", "public host ip address or hostname")
+	cmd.Flags().StringVar(&opt.tlsCert, "tls_cert", "", "server certificate, tls is disabled if empty")
+	cmd.Flags().StringVar(&opt.tlsKey, "tls_key", "", "server certificate key")
+	cmd.Flags().StringVar(&opt.ca, "ca", "", "ca certificate used to verify client certificates, client certificates are not required if empty")
+	cmd.Flags().StringVar(&opt.metricsAddr, "metrics_addr", "", "address to serve prometheus metrics on /metrics, disabled if empty")
+	cmd.Flags().StringVar(&opt.portRange, "pub_port_range", "", "public ports of clients are pinned in this range(e.g. 20000-20100)")
+	cmd.Flags().Int32Var(&opt.intPort, "int_port", 0, "internal port shared by all clients, each client uses its own internal port if 0")
+	cmd.Flags().DurationVar(&opt.pairTimeout, "pair_timeout", 30*time.Second, "backend service users not claimed by l4proxy client in this duration are closed, 0 means forever")
+	cmd.Flags().DurationVar(&opt.idleTimeout, "idle_timeout", 0, "default of clients, users without bytes in either direction in this duration are closed, 0 means forever")
+	cmd.Flags().DurationVar(&opt.maxSession, "max_session_duration", 0, "default of clients, users connected for this duration are closed, 0 means forever")
+	cmd.Flags().DurationVar(&opt.keepAlive, "keepalive_interval", 0, "default of clients, tcp keepalive period of public and internal connections, system default if 0")
+	cmd.Flags().DurationVar(&opt.drainTimeout, "drain_timeout", 30*time.Second, "on interrupt or drain, the time clients wait for existing backend service users before closed, 0 means forever")
+	cmd.Flags().DurationVar(&opt.sessionGrace, "session_grace", 30*time.Second, "time a client is kept after its control stream breaks, l4proxy client reconnecting in time resumes it with the same public address, 0 means closed at once")
+	cmd.Flags().StringVar(&opt.quotaState, "quota_state_file", "", "file quota usage is saved to periodically and on exit, usage is reset when server restarts if empty")
+	cmd.Flags().DurationVar(&opt.udpIdle, "udp_idle_timeout", time.Minute, "udp sessions without traffic in this duration are closed, 0 means forever")
+	cmd.Flags().Int64Var(&opt.maxUsers, "max_users", 0, "max concurrent backend service users of all clients, unlimited if 0")
+	cmd.Flags().Int64Var(&opt.maxUsersPer, "max_users_per_client", 0, "max concurrent backend service users of each client, caps max users requested by clients, unlimited if 0")
+	cmd.Flags().Float64Var(&opt.ipConnRate, "max_new_conns_per_ip", 0, "max new connections per second from a source ip to public listeners, unlimited if 0")
+	cmd.Flags().BoolVar(&opt.acceptProxy, "accept_proxy_protocol", false, "read PROXY protocol v1 or v2 header from users of public tcp listeners, enable it only behind a load balancer sending the header")
+	cmd.Flags().BoolVar(&opt.dataTLS, "data_tls", false, "encrypt data connections from clients with the server certificate")
+	cmd.Flags().StringVar(&opt.authToken, "auth_token", "", "pre-shared token which is allowed to call any api")
+	cmd.Flags().StringVar(&opt.authPolicy, "auth_policy", "", "policy file(yaml, json or toml) mapping api keys to allowed display names and public ports")
 	return cmd
 }
 
+// loadAuthPolicy returns nil if authentication is disabled.
+func loadAuthPolicy() (*auth.Policy, error) {
+	if opt.authToken == "" && opt.authPolicy == "" {
+		return nil, nil
+	}
+	policy := &auth.Policy{}
+	if opt.authPolicy != "" {
+		var err error
+		policy, err = auth.LoadPolicy(opt.authPolicy)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if opt.authToken != "" {
+		policy.AddToken(opt.authToken)
+	}
+	return policy, nil
+}
+
 func newForwarderCmd() *cobra.Command {
 	var pubPort int32
-	var svrAddr string
+	var clientOpt client.Options
 	cmd := &cobra.Command{
 		Use:   "forwarder",
 		Short: "create a forwarder service on server side",
 		Run: func(cmd *cobra.Command, args []string) {
-			c, err := grpc.Dial(svrAddr, grpc.WithInsecure())
+			c, err := client.Dial(&clientOpt)
 			if err != nil {
 				log.Fatalf("failed to dial to grpc server: %v", err)
 			}
-			client := api.NewControlServiceClient(c)
-			resp, err := client.StartInternalService(context.TODO(), &api.StartInternalServiceRequest{
+			cc := api.NewControlServiceClient(c)
+			resp, err := cc.StartInternalService(context.TODO(), &api.StartInternalServiceRequest{
 				ServiceName: "l7forwarder",
 				PubPort:     pubPort,
 			})
@@ -122,13 +278,14 @@ func newForwarderCmd() *cobra.Command {
 			fmt.Printf("new forwarder %s(%s) created\n", resp.Name, resp.Addr)
 		},
 	}
-	cmd.Flags().StringVar(&svrAddr, "svr_addr", "127.0.0.1:2222", "server address.")
+	cmd.Flags().StringVar(&clientOpt.SvrAddr, "svr_addr", "127.0.0.1:2222", "server address.")
+	client.AddSecurityFlags(cmd.Flags(), &clientOpt)
 	cmd.Flags().Int32Var(&pubPort, "pub_port", 0, "public port for the forwarder")
 	return cmd
 }
 
 func newCmd() *cobra.Command {
-	client := cmd.NewClientCmd()
+	clientCmd := cmd.NewClientCmd()
 	svr := newServerCmd()
 	forward := newForwarderCmd()
 	svr.AddCommand(forward)
@@ -137,7 +294,7 @@ func newCmd() *cobra.Command {
 		Use:  "l4proxy",
 		Long: "reverse proxy",
 	}
-	cmd.AddCommand(client, svr, lan)
+	cmd.AddCommand(clientCmd, svr, lan)
 	return cmd
 }
 
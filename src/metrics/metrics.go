@@ -0,0 +1,70 @@
+package metrics
+
+import (
+	"net/http"
+
+	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+const namespace = "l4proxy"
+
+var (
+	ActiveConnections = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_connections",
+		Help:      "Number of paired connections of backend service users.",
+	}, []string{"display_name"})
+	AcceptedUsers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "accepted_users_total",
+		Help:      "Total number of backend service user connections accepted on public listeners.",
+	}, []string{"display_name"})
+	RejectedUsers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rejected_users_total",
+		Help:      "Total number of backend service user connections rejected by limits, reason is access_list, detached, draining, ip_rate, max_conns, max_users, quota or unhealthy.",
+	}, []string{"display_name", "reason"})
+	UnclaimedUsers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "unclaimed_users_total",
+		Help:      "Total number of backend service users closed because l4proxy client did not connect in pair timeout.",
+	}, []string{"display_name"})
+	BytesIn = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bytes_in_total",
+		Help:      "Total bytes copied from backend service users to backends.",
+	}, []string{"display_name"})
+	BytesOut = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bytes_out_total",
+		Help:      "Total bytes copied from backends to backend service users.",
+	}, []string{"display_name"})
+	DialFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dial_failures_total",
+		Help:      "Total number of failed dials, target is stun or backend.",
+	}, []string{"display_name", "target"})
+	STUN = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stun_total",
+		Help:      "Total number of stun attempts, result is success or fallback.",
+	}, []string{"result"})
+)
+
+// Serve serves metrics on addr in background.
+// Latency histograms of grpc calls are enabled.
+func Serve(addr string) {
+	grpc_prometheus.EnableHandlingTimeHistogram()
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		log.Infof("serve metrics on %s", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Errorf("serve metrics failed: %v", err)
+		}
+	}()
+}
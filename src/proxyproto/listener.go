@@ -0,0 +1,106 @@
+package proxyproto
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// conn overrides addresses of a connection by the header.
+type conn struct {
+	net.Conn
+	header *Header
+}
+
+func (c *conn) RemoteAddr() net.Addr {
+	if c.header.Src != nil {
+		return c.header.Src
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// NetConn returns the underlying connection.
+func (c *conn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *conn) LocalAddr() net.Addr {
+	if c.header.Dst != nil {
+		return c.header.Dst
+	}
+	return c.Conn.LocalAddr()
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// Listener reads PROXY protocol header of each accepted connection,
+// RemoteAddr and LocalAddr of the connections are from the header.
+// Connections without a valid header in timeout are closed.
+type Listener struct {
+	net.Listener
+	timeout time.Duration
+	ch      chan acceptResult
+	done    chan struct{}
+	once    sync.Once
+}
+
+func NewListener(ltn net.Listener, timeout time.Duration) *Listener {
+	l := &Listener{
+		Listener: ltn,
+		timeout:  timeout,
+		ch:       make(chan acceptResult),
+		done:     make(chan struct{}),
+	}
+	go l.serve()
+	return l
+}
+
+func (l *Listener) serve() {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			select {
+			case l.ch <- acceptResult{err: err}:
+			case <-l.done:
+			}
+			// connections reading headers are closed.
+			l.once.Do(func() {
+				close(l.done)
+			})
+			return
+		}
+		// headers are read concurrently so that a slow peer does not block others.
+		go func(c net.Conn) {
+			c.SetReadDeadline(time.Now().Add(l.timeout))
+			h, err := Read(c)
+			if err != nil {
+				log.Warnf("read proxy protocol header from %s failed: %v", c.RemoteAddr(), err)
+				c.Close()
+				return
+			}
+			c.SetReadDeadline(time.Time{})
+			select {
+			case l.ch <- acceptResult{conn: &conn{Conn: c, header: h}}:
+			case <-l.done:
+				c.Close()
+			}
+		}(c)
+	}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	r := <-l.ch
+	return r.conn, r.err
+}
+
+func (l *Listener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+	})
+	return l.Listener.Close()
+}
@@ -0,0 +1,210 @@
+// Package proxyproto implements HAProxy PROXY protocol v1 and v2 headers,
+// see https://www.haproxy.org/download/2.0/doc/proxy-protocol.txt
+package proxyproto
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+const (
+	V1 = "v1"
+	V2 = "v2"
+)
+
+// v2Signature starts every v2 header.
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+const (
+	v2VersionCommandLocal = 0x20
+	v2VersionCommandProxy = 0x21
+	v2FamilyTCP4          = 0x11
+	v2FamilyUDP4          = 0x12
+	v2FamilyTCP6          = 0x21
+	v2FamilyUDP6          = 0x22
+	v2FamilyUnspec        = 0x00
+)
+
+// v1MaxLen is the max length of a v1 header including CRLF.
+const v1MaxLen = 107
+
+// Header is the source and destination of a proxied tcp connection,
+// both are nil if unknown.
+type Header struct {
+	Src *net.TCPAddr
+	Dst *net.TCPAddr
+}
+
+// ParseAddr parses host:port with ip host.
+func ParseAddr(addr string) (*net.TCPAddr, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid ip %s", host)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, err
+	}
+	return &net.TCPAddr{IP: ip, Port: p}, nil
+}
+
+// sameFamily returns src and dst as ipv4 if both are ipv4, or as ipv6.
+func (h *Header) sameFamily() (src, dst net.IP, v4 bool) {
+	src4, dst4 := h.Src.IP.To4(), h.Dst.IP.To4()
+	if src4 != nil && dst4 != nil {
+		return src4, dst4, true
+	}
+	return h.Src.IP.To16(), h.Dst.IP.To16(), false
+}
+
+// Format returns the header of version v1 or v2.
+func (h *Header) Format(version string) ([]byte, error) {
+	switch version {
+	case V1:
+		return h.formatV1(), nil
+	case V2:
+		return h.formatV2(), nil
+	}
+	return nil, fmt.Errorf("unknown proxy protocol version %s", version)
+}
+
+func (h *Header) formatV1() []byte {
+	if h.Src == nil || h.Dst == nil {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+	src, dst, v4 := h.sameFamily()
+	proto := "TCP6"
+	if v4 {
+		proto = "TCP4"
+	}
+	return []byte(fmt.Sprintf("PROXY %s %s %s %d %d\r\n", proto, src, dst, h.Src.Port, h.Dst.Port))
+}
+
+func (h *Header) formatV2() []byte {
+	buf := bytes.NewBuffer(nil)
+	buf.Write(v2Signature)
+	buf.WriteByte(v2VersionCommandProxy)
+	if h.Src == nil || h.Dst == nil {
+		buf.WriteByte(v2FamilyUnspec)
+		binary.Write(buf, binary.BigEndian, uint16(0))
+		return buf.Bytes()
+	}
+	src, dst, v4 := h.sameFamily()
+	if v4 {
+		buf.WriteByte(v2FamilyTCP4)
+	} else {
+		buf.WriteByte(v2FamilyTCP6)
+	}
+	binary.Write(buf, binary.BigEndian, uint16(2*len(src)+4))
+	buf.Write(src)
+	buf.Write(dst)
+	binary.Write(buf, binary.BigEndian, uint16(h.Src.Port))
+	binary.Write(buf, binary.BigEndian, uint16(h.Dst.Port))
+	return buf.Bytes()
+}
+
+// Write writes the header of version to w.
+func (h *Header) Write(w io.Writer, version string) error {
+	b, err := h.Format(version)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// Read reads a v1 or v2 header from r, no bytes after the header are read.
+func Read(r io.Reader) (*Header, error) {
+	// shorter than any v1 header and the v2 signature.
+	prefix := make([]byte, 12)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, err
+	}
+	if bytes.Equal(prefix, v2Signature) {
+		return readV2(r)
+	}
+	if bytes.HasPrefix(prefix, []byte("PROXY ")) {
+		return readV1(r, prefix)
+	}
+	return nil, fmt.Errorf("invalid proxy protocol header")
+}
+
+func readV1(r io.Reader, prefix []byte) (*Header, error) {
+	line := prefix
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= v1MaxLen {
+			return nil, fmt.Errorf("proxy protocol v1 header is too long")
+		}
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		line = append(line, b[0])
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return &Header{}, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("invalid proxy protocol v1 header %q", line)
+	}
+	src, err := ParseAddr(net.JoinHostPort(fields[2], fields[4]))
+	if err != nil {
+		return nil, err
+	}
+	dst, err := ParseAddr(net.JoinHostPort(fields[3], fields[5]))
+	if err != nil {
+		return nil, err
+	}
+	return &Header{Src: src, Dst: dst}, nil
+}
+
+func readV2(r io.Reader) (*Header, error) {
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+	body := make([]byte, binary.BigEndian.Uint16(head[2:]))
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	switch head[0] {
+	case v2VersionCommandLocal:
+		return &Header{}, nil
+	case v2VersionCommandProxy:
+	default:
+		return nil, fmt.Errorf("invalid proxy protocol v2 version and command %#x", head[0])
+	}
+	ipLen := 0
+	switch head[1] {
+	case v2FamilyTCP4, v2FamilyUDP4:
+		ipLen = net.IPv4len
+	case v2FamilyTCP6, v2FamilyUDP6:
+		ipLen = net.IPv6len
+	default:
+		// unix sockets and unspec are treated as unknown.
+		return &Header{}, nil
+	}
+	if len(body) < 2*ipLen+4 {
+		return nil, fmt.Errorf("proxy protocol v2 address is too short")
+	}
+	return &Header{
+		Src: &net.TCPAddr{
+			IP:   net.IP(body[:ipLen]),
+			Port: int(binary.BigEndian.Uint16(body[2*ipLen:])),
+		},
+		Dst: &net.TCPAddr{
+			IP:   net.IP(body[ipLen : 2*ipLen]),
+			Port: int(binary.BigEndian.Uint16(body[2*ipLen+2:])),
+		},
+	}, nil
+}
@@ -0,0 +1,131 @@
+package proxyproto
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+)
+
+func mustAddr(t *testing.T, addr string) *net.TCPAddr {
+	a, err := ParseAddr(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func sameAddr(a, b *net.TCPAddr) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.IP.Equal(b.IP) && a.Port == b.Port
+}
+
+func TestRoundTrip(t *testing.T) {
+	cases := []struct {
+		name    string
+		version string
+		src     string
+		dst     string
+	}{
+		{"v1 ipv4", V1, "192.168.1.2:40000", "10.0.0.1:443"},
+		{"v1 ipv6", V1, "[2001:db8::1]:40000", "[2001:db8::2]:443"},
+		{"v1 mixed", V1, "192.168.1.2:40000", "[2001:db8::2]:443"},
+		{"v1 unknown", V1, "", ""},
+		{"v2 ipv4", V2, "192.168.1.2:40000", "10.0.0.1:443"},
+		{"v2 ipv6", V2, "[2001:db8::1]:40000", "[2001:db8::2]:443"},
+		{"v2 mixed", V2, "192.168.1.2:40000", "[2001:db8::2]:443"},
+		{"v2 unknown", V2, "", ""},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h := &Header{}
+			if c.src != "" {
+				h.Src, h.Dst = mustAddr(t, c.src), mustAddr(t, c.dst)
+			}
+			buf := bytes.NewBuffer(nil)
+			if err := h.Write(buf, c.version); err != nil {
+				t.Fatal(err)
+			}
+			buf.WriteString("payload")
+			got, err := Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameAddr(got.Src, h.Src) || !sameAddr(got.Dst, h.Dst) {
+				t.Fatalf("got %v -> %v, want %v -> %v", got.Src, got.Dst, h.Src, h.Dst)
+			}
+			rest, _ := ioutil.ReadAll(buf)
+			if string(rest) != "payload" {
+				t.Fatalf("bytes after header are %q, want payload", rest)
+			}
+		})
+	}
+}
+
+func TestFormatUnknownVersion(t *testing.T) {
+	if _, err := (&Header{}).Format("v3"); err == nil {
+		t.Fatal("v3 is formatted")
+	}
+}
+
+func TestRead(t *testing.T) {
+	v2 := string(v2Signature)
+	v4Body := "\xc0\xa8\x01\x02\x0a\x00\x00\x01\x9c\x40\x01\xbb"
+	cases := []struct {
+		name    string
+		header  string
+		src     string
+		dst     string
+		wantErr bool
+	}{
+		{name: "v1 tcp4", header: "PROXY TCP4 192.168.1.2 10.0.0.1 40000 443\r\n", src: "192.168.1.2:40000", dst: "10.0.0.1:443"},
+		{name: "v1 unknown", header: "PROXY UNKNOWN\r\n"},
+		{name: "v1 unknown with addresses", header: "PROXY UNKNOWN 192.168.1.2 10.0.0.1 40000 443\r\n"},
+		{name: "v1 without crlf", header: "PROXY TCP4 192.168.1.2 10.0.0.1 40000 443", wantErr: true},
+		{name: "v1 too long", header: "PROXY TCP4 " + strings.Repeat("1", v1MaxLen) + "\r\n", wantErr: true},
+		{name: "v1 unknown protocol", header: "PROXY UDP4 192.168.1.2 10.0.0.1 40000 443\r\n", wantErr: true},
+		{name: "v1 missing port", header: "PROXY TCP4 192.168.1.2 10.0.0.1 40000\r\n", wantErr: true},
+		{name: "v1 invalid ip", header: "PROXY TCP4 192.168.1 10.0.0.1 40000 443\r\n", wantErr: true},
+		{name: "v1 invalid port", header: "PROXY TCP4 192.168.1.2 10.0.0.1 port 443\r\n", wantErr: true},
+		{name: "v2 tcp4", header: v2 + "\x21\x11\x00\x0c" + v4Body, src: "192.168.1.2:40000", dst: "10.0.0.1:443"},
+		{name: "v2 udp4", header: v2 + "\x21\x12\x00\x0c" + v4Body, src: "192.168.1.2:40000", dst: "10.0.0.1:443"},
+		{name: "v2 tlvs after address", header: v2 + "\x21\x11\x00\x0f" + v4Body + "\x04\x00\x00", src: "192.168.1.2:40000", dst: "10.0.0.1:443"},
+		{name: "v2 local", header: v2 + "\x20\x11\x00\x0c" + v4Body},
+		{name: "v2 unspec", header: v2 + "\x21\x00\x00\x00"},
+		{name: "v2 unix", header: v2 + "\x21\x31\x00\x04abcd"},
+		{name: "v2 truncated length", header: v2 + "\x21\x11\x00", wantErr: true},
+		{name: "v2 truncated body", header: v2 + "\x21\x11\x00\x0c\xc0\xa8\x01", wantErr: true},
+		{name: "v2 short address", header: v2 + "\x21\x11\x00\x08\xc0\xa8\x01\x02\x0a\x00\x00\x01", wantErr: true},
+		{name: "v2 bad version", header: v2 + "\x11\x11\x00\x0c" + v4Body, wantErr: true},
+		{name: "v2 bad command", header: v2 + "\x22\x11\x00\x0c" + v4Body, wantErr: true},
+		{name: "bad signature", header: "\r\n\r\n\x00\r\nQUIT\r" + "\x21\x11\x00\x0c" + v4Body, wantErr: true},
+		{name: "plain http", header: "GET / HTTP/1.1\r\nHost: a\r\n\r\n", wantErr: true},
+		{name: "lower case v1", header: "proxy TCP4 192.168.1.2 10.0.0.1 40000 443\r\n", wantErr: true},
+		{name: "short prefix", header: "PROXY", wantErr: true},
+		{name: "empty", header: "", wantErr: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h, err := Read(strings.NewReader(c.header))
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("got %v -> %v, want error", h.Src, h.Dst)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var src, dst *net.TCPAddr
+			if c.src != "" {
+				src, dst = mustAddr(t, c.src), mustAddr(t, c.dst)
+			}
+			if !sameAddr(h.Src, src) || !sameAddr(h.Dst, dst) {
+				t.Fatalf("got %v -> %v, want %v -> %v", h.Src, h.Dst, src, dst)
+			}
+		})
+	}
+}
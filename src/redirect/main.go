@@ -8,15 +8,15 @@ import (
 	"github.com/labstack/echo"
 	"github.com/spf13/cobra"
 	"github.com/winglq/l4proxy/src/api"
-	"google.golang.org/grpc"
+	"github.com/winglq/l4proxy/src/client"
 )
 
-var l4svrAddr = "127.0.0.1:2222"
+var clientOpt = client.Options{SvrAddr: "127.0.0.1:2222"}
 var displayName = "mstream"
 var addr = ":3000"
 
 func redirect(c echo.Context) error {
-	addr := getRedirectToIP(l4svrAddr, displayName)
+	addr := getRedirectToIP(&clientOpt, displayName)
 	if addr == "" {
 		c.String(http.StatusNotFound, "not found redirect url")
 		return nil
@@ -35,7 +35,8 @@ func main() {
 
 		},
 	}
-	cmd.Flags().StringVar(&l4svrAddr, "svr_addr", l4svrAddr, "l4proxy server address")
+	cmd.Flags().StringVar(&clientOpt.SvrAddr, "svr_addr", clientOpt.SvrAddr, "l4proxy server address")
+	client.AddSecurityFlags(cmd.Flags(), &clientOpt)
 	cmd.Flags().StringVar(&displayName, "display", displayName, "display name of the public ip this service will direct to")
 	cmd.Flags().StringVar(&addr, "addr", addr, "http server listen address")
 	if err := cmd.Execute(); err != nil {
@@ -43,14 +44,14 @@ func main() {
 	}
 }
 
-func getRedirectToIP(l4svrAddr, displayName string) string {
-	c, err := grpc.Dial(l4svrAddr, grpc.WithInsecure())
+func getRedirectToIP(opt *client.Options, displayName string) string {
+	c, err := client.Dial(opt)
 	if err != nil {
 		log.Fatalf("failed to dial to grpc server: %v", err)
 		return ""
 	}
-	client := api.NewControlServiceClient(c)
-	resp, err := client.ListClients(context.TODO(), &api.ListClientsRequest{})
+	cc := api.NewControlServiceClient(c)
+	resp, err := cc.ListClients(context.TODO(), &api.ListClientsRequest{})
 	if err != nil {
 		log.Fatalf("get response failed: %v", err)
 		return ""
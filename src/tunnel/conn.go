@@ -0,0 +1,120 @@
+package tunnel
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/yamux"
+	"github.com/winglq/l4proxy/src/api"
+)
+
+// ClientMetadataKey is the metadata key which carries client name when opening a tunnel.
+const ClientMetadataKey = "l4proxy-client"
+
+// Stream is implemented by both client and server side of ControlService_TunnelClient.
+type Stream interface {
+	Send(*api.TunnelData) error
+	Recv() (*api.TunnelData, error)
+}
+
+// Conn adapts a grpc tunnel stream to io.ReadWriteCloser,
+// so that it can be used as the transport of a yamux session.
+type Conn struct {
+	s       Stream
+	buf     []byte
+	recvCH  chan []byte
+	recvErr error
+	sendMu  sync.Mutex
+	done    chan struct{}
+	once    sync.Once
+	onClose func()
+}
+
+// NewConn creates a conn on s, onClose is called once when conn is closed.
+func NewConn(s Stream, onClose func()) *Conn {
+	c := &Conn{
+		s:       s,
+		recvCH:  make(chan []byte),
+		done:    make(chan struct{}),
+		onClose: onClose,
+	}
+	go c.recv()
+	return c
+}
+
+func (c *Conn) recv() {
+	defer close(c.recvCH)
+	for {
+		m, err := c.s.Recv()
+		if err != nil {
+			c.recvErr = err
+			return
+		}
+		select {
+		case c.recvCH <- m.Data:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	for len(c.buf) == 0 {
+		select {
+		case data, ok := <-c.recvCH:
+			if !ok {
+				if c.recvErr == nil || c.recvErr == io.EOF {
+					return 0, io.EOF
+				}
+				return 0, c.recvErr
+			}
+			c.buf = data
+		case <-c.done:
+			return 0, io.ErrClosedPipe
+		}
+	}
+	n := copy(p, c.buf)
+	c.buf = c.buf[n:]
+	return n, nil
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	select {
+	case <-c.done:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	if err := c.s.Send(&api.TunnelData{Data: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *Conn) Close() error {
+	c.once.Do(func() {
+		close(c.done)
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+	return nil
+}
+
+func config() *yamux.Config {
+	cfg := yamux.DefaultConfig()
+	cfg.KeepAliveInterval = 15 * time.Second
+	return cfg
+}
+
+// Server creates the server side session on s.
+func Server(s Stream, onClose func()) (*yamux.Session, error) {
+	return yamux.Server(NewConn(s, onClose), config())
+}
+
+// Client creates the client side session on s.
+func Client(s Stream, onClose func()) (*yamux.Session, error) {
+	return yamux.Client(NewConn(s, onClose), config())
+}